@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jessevdk/go-flags"
@@ -12,18 +14,42 @@ import (
 )
 
 type opts struct {
-	Doc       string `short:"f" long:"file" description:"File to import includes into."`
-	LookupDir string `short:"d" long:"dir" description:"Path to dir containing markdown files to search." default:"."`
-	Backup    bool   `short:"b" long:"backup" description:"Backup the original target document beforehand."`
-	List      bool   `short:"l" long:"list" description:"List all available backups."`
-	Restore   string `short:"r" long:"restore" description:"Restore to a specified backup of given ID."`
-	Debug     bool   `short:"v" long:"verbose" description:"Displays all internal/debug logs to assist with user level debugging."`
+	Doc           string   `short:"f" long:"file" description:"File to import includes into, or - to read from stdin."`
+	Output        string   `short:"o" long:"output" description:"File to write the result to, or - for stdout. Defaults to the file being imported into."`
+	LookupDir     string   `short:"d" long:"dir" description:"Path to dir containing markdown files to search." default:"."`
+	IncludePaths  []string `short:"I" long:"include-path" description:"Additional dir to search for includes, searched in the order given after --dir. Can be repeated."`
+	Backup        bool     `short:"b" long:"backup" description:"Backup the original target document beforehand."`
+	Markers       bool     `short:"m" long:"markers" description:"Keep include directives and wrap included content in markers so the document can be rebuilt."`
+	MaxDepth      int      `long:"max-depth" description:"Maximum depth includes can be nested to, or -1 for no limit." default:"32"`
+	Defines       []string `short:"D" long:"define" description:"Define a variable as NAME=value for ${NAME} placeholders to expand to. Can be repeated."`
+	Env           bool     `long:"env" description:"Make environment variables available for ${NAME} placeholders to expand to."`
+	KeepUndefined bool     `long:"keep-undefined" description:"Leave placeholders for undefined variables as they are rather than failing."`
+	FrontMatter   string   `long:"front-matter" description:"How to handle the front matter of included documents." choice:"strip" choice:"keep" choice:"merge" default:"strip"`
+	Anchors       string   `long:"anchors" description:"How to handle heading anchors which collide across included documents." choice:"unique" choice:"warn" default:"unique"`
+	Number        bool     `short:"n" long:"number-sections" description:"Prefix headings with hierarchical section numbers, other than those ending with {-} or {.unnumbered}."`
+	NumberLevels  string   `long:"number-levels" description:"Range of heading levels to number, as MIN-MAX." default:"1-6"`
+	Offline       bool     `long:"offline" description:"Resolve remote includes from the cache only, without fetching them."`
+	Delims        string   `long:"delims" description:"Open and close delimiters, separated by a space, which directives can be wrapped within." default:"<!-- -->"`
+	List          bool     `short:"l" long:"list" description:"List all available backups."`
+	Restore       string   `short:"r" long:"restore" description:"Restore to a specified backup of given ID."`
+	Debug         bool     `short:"v" long:"verbose" description:"Displays all internal/debug logs to assist with user level debugging."`
 }
 
+// pinCommand is the pin subcommand, which rewrites the remote include
+// directives of the document to pin them to their current content.
+type pinCommand struct{}
+
+// status is where messages about the run are written, moved
+// off stdout when the resolved document is written there instead
+var status io.Writer = os.Stdout
+
 func backup(run bool, path string, doc *md.Document) {
 	if run {
+		if path == md.Stdin {
+			logging.Fatal("unable to backup a document read from stdin")
+		}
 		id, bf, err := md.Backup(doc)
-		fmt.Printf("Backed up %s to %s %s: %s\n", id, path, bf, func() string {
+		fmt.Fprintf(status, "Backed up %s to %s %s: %s\n", id, path, bf, func() string {
 			if err != nil {
 				return "FAILED"
 			}
@@ -47,7 +73,7 @@ func restore(backupID string) (bool, error) {
 
 	for _, bkup := range backupFiles {
 		if bkup.ID == backupID {
-			fmt.Printf("restoring backup %s to %s\n", bkup.ID, bkup.Path)
+			fmt.Fprintf(status, "restoring backup %s to %s\n", bkup.ID, bkup.Path)
 			return true, md.Restore(bkup)
 		}
 	}
@@ -76,9 +102,50 @@ func listBackups(run bool) bool {
 	return run
 }
 
+func pin(opts opts) error {
+	doc, err := md.Open(opts.Doc)
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+
+	if err := doc.Configure(options(opts)...); err != nil {
+		return err
+	}
+
+	pinned, err := doc.Pin()
+	if err != nil {
+		return err
+	}
+
+	out, err := openOutput(opts.Doc, opts.Output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := doc.Write(out); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(status, "pinned %d remote includes in %s\n", pinned, opts.Doc)
+	return nil
+}
+
 func main() {
 	opts := opts{}
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	if _, err := parser.AddCommand(
+		"pin",
+		"Pin remote includes to their current content",
+		"Adds or refreshes the sha256 attribute of each remote include directive within the document, so resolution fails if their content changes.",
+		&pinCommand{},
+	); err != nil {
+		logging.Fatal(err.Error())
+	}
+
+	if _, err := parser.Parse(); err != nil {
 		logging.Fatal(err.Error())
 	}
 
@@ -100,6 +167,18 @@ func main() {
 		logging.Fatal("the required flag `-f, --file' was not specified")
 	}
 
+	if opts.Output == md.Stdin || (opts.Doc == md.Stdin && len(opts.Output) == 0) {
+		status = os.Stderr
+		log.WRITER = os.Stderr
+	}
+
+	if parser.Active != nil && parser.Active.Name == "pin" {
+		if err := pin(opts); err != nil {
+			logging.Fatal(err.Error())
+		}
+		return
+	}
+
 	doc, err := md.Open(opts.Doc)
 	if err != nil {
 		logging.Fatal(err.Error())
@@ -108,15 +187,91 @@ func main() {
 
 	backup(opts.Backup, opts.Doc, doc)
 
+	if err := doc.Configure(options(opts)...); err != nil {
+		logging.Fatal(err.Error())
+	}
+
 	if err := doc.ResolveIncludes(opts.LookupDir); err != nil {
 		logging.Fatal(err.Error())
 	}
 
-	dfd, err := os.Create(opts.Doc)
+	out, err := openOutput(opts.Doc, opts.Output)
 	if err != nil {
 		logging.Fatal(err.Error())
 	}
 
-	doc.Write(dfd)
-	dfd.Close()
+	for _, w := range doc.Warnings() {
+		fmt.Fprintf(status, "warning: %s\n", w)
+	}
+
+	doc.Write(out)
+	out.Close()
+}
+
+func options(opts opts) []md.Option {
+	mdopts := []md.Option{md.WithMaxDepth(opts.MaxDepth), md.WithIncludePaths(opts.IncludePaths...)}
+	if opts.Markers {
+		mdopts = append(mdopts, md.WithMarkers())
+	}
+	if opts.Offline {
+		mdopts = append(mdopts, md.WithOffline())
+	}
+	if len(opts.Output) > 0 && opts.Output != md.Stdin {
+		mdopts = append(mdopts, md.WithOutputPath(opts.Output))
+	}
+
+	if opts.Anchors == "warn" {
+		mdopts = append(mdopts, md.WithAnchors(md.WarnAnchors))
+	}
+
+	if opts.Number {
+		var min, max int
+		if _, err := fmt.Sscanf(opts.NumberLevels, "%d-%d", &min, &max); err != nil {
+			logging.Fatal(fmt.Sprintf("--number-levels %q must be given as MIN-MAX", opts.NumberLevels))
+		}
+		mdopts = append(mdopts, md.WithSectionNumbers(min, max))
+	}
+
+	switch opts.FrontMatter {
+	case "keep":
+		mdopts = append(mdopts, md.WithFrontMatter(md.KeepFrontMatter))
+	case "merge":
+		mdopts = append(mdopts, md.WithFrontMatter(md.MergeFrontMatter))
+	}
+
+	defines := map[string]string{}
+	for _, d := range opts.Defines {
+		kv := strings.SplitN(d, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			logging.Fatal(fmt.Sprintf("--define %q must be given as NAME=value", d))
+		}
+		defines[kv[0]] = kv[1]
+	}
+	mdopts = append(mdopts, md.WithDefines(defines))
+	if opts.Env {
+		mdopts = append(mdopts, md.WithEnvironment())
+	}
+	if opts.KeepUndefined {
+		mdopts = append(mdopts, md.WithUndefinedVariables())
+	}
+
+	delims := strings.Fields(opts.Delims)
+	if len(delims) != 2 {
+		logging.Fatal("--delims must be an open and close delimiter separated by a space")
+	}
+	mdopts = append(mdopts, md.WithDirectiveDelims(delims[0], delims[1]))
+
+	return mdopts
+}
+
+func openOutput(doc, output string) (io.WriteCloser, error) {
+	if len(output) == 0 {
+		output = doc
+	}
+
+	if output == md.Stdin {
+		return os.Stdout, nil
+	}
+
+	return os.Create(output)
 }
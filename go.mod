@@ -3,19 +3,17 @@ module github.com/tauraamui/imdclude
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/matryer/is v1.4.0
-	github.com/stretchr/testify v1.7.1
 	github.com/tacusci/logging/v2 v2.1.1
 	github.com/teris-io/shortid v0.0.0-20201117134242-e59966efd125
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/fatih/color v1.10.0 // indirect
 	github.com/mattn/go-colorable v0.1.8 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20210320140829-1e4c9ba3b0c4 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -1,17 +1,24 @@
 package logging
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
 
 var OUTPUT = false
 
+// WRITER is where logs are written to when OUTPUT is enabled.
+var WRITER io.Writer = os.Stdout
+
 func Println(l string) {
 	if OUTPUT {
-		fmt.Println(l)
+		fmt.Fprintln(WRITER, l)
 	}
 }
 
 func Printfln(l string, a ...interface{}) {
 	if OUTPUT {
-		fmt.Printf(l+"\n", a...)
+		fmt.Fprintf(WRITER, l+"\n", a...)
 	}
 }
@@ -0,0 +1,136 @@
+package md
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var explicitIDRegex = regexp.MustCompile(`^<a id="[^"]*"></a>`)
+
+// AnchorMode is how headings from different included documents, whose
+// anchors collide within the resolved document, are handled.
+type AnchorMode int
+
+const (
+	// UniqueAnchors gives each heading whose anchor collides with an earlier
+	// one an explicit ID of the unique anchor GitHub generates for it, and
+	// updates the links within the same document to point to that anchor.
+	UniqueAnchors AnchorMode = iota
+	// WarnAnchors leaves headings and links as they are, only adding a
+	// warning for each heading whose anchor collides with an earlier one.
+	WarnAnchors
+)
+
+// WithAnchors sets how colliding heading anchors are handled, which by
+// default is to make them unique.
+func WithAnchors(mode AnchorMode) Option {
+	return func(o *options) {
+		o.anchors = mode
+	}
+}
+
+// Warnings returns the problems found while resolving the document
+// which weren't severe enough to fail its resolution.
+func (d *Document) Warnings() []string {
+	return d.warnings
+}
+
+// uniqueAnchors finds the headings within the resolved document whose anchors
+// differ from those they had within the document they came from, due to them
+// colliding with the anchors of earlier headings from other documents.
+func (d *Document) uniqueAnchors() {
+	headings := findHeadings(d.lineContent)
+	global := anchors(headings)
+	origins := d.lineOrigins(len(d.lineContent))
+	order, local := localAnchors(headings, origins)
+
+	moved := map[*Document]map[string]string{}
+	for _, i := range order {
+		h := headings[i]
+		if len(h.text) == 0 || local[i] == global[i] {
+			continue
+		}
+
+		o := origins[h.line]
+		if d.opts.anchors == WarnAnchors {
+			d.warnings = append(d.warnings, fmt.Sprintf("%s: anchor #%s of heading %q collides with an earlier heading, so is #%s", o.displayKey(), local[i], h.text, global[i]))
+			continue
+		}
+
+		if moved[o] == nil {
+			moved[o] = map[string]string{}
+		}
+		moved[o][local[i]] = global[i]
+		d.lineContent[h.line] = explicitID(d.lineContent[h.line], global[i])
+	}
+
+	d.relinkAnchors(origins, moved)
+}
+
+// localAnchors returns the anchor each heading has within the document it came
+// from, given the origin of each line, along with the indices of the headings
+// grouped by document in the order the documents first appear.
+func localAnchors(headings []heading, origins []*Document) ([]int, []string) {
+	order, byOrigin := []*Document{}, map[*Document][]int{}
+	for i, h := range headings {
+		o := origins[h.line]
+		if _, ok := byOrigin[o]; !ok {
+			order = append(order, o)
+		}
+		byOrigin[o] = append(byOrigin[o], i)
+	}
+
+	grouped, result := make([]int, 0, len(headings)), make([]string, len(headings))
+	for _, o := range order {
+		indices := byOrigin[o]
+		grouped = append(grouped, indices...)
+		hs := make([]heading, 0, len(indices))
+		for _, i := range indices {
+			hs = append(hs, headings[i])
+		}
+		for j, a := range anchors(hs) {
+			result[indices[j]] = a
+		}
+	}
+	return grouped, result
+}
+
+// relinkAnchors updates the links to the anchors of headings which have moved,
+// with the links on lines from each document only updated for the anchors
+// which have moved within that same document.
+func (d *Document) relinkAnchors(origins []*Document, moved map[*Document]map[string]string) {
+	if len(moved) == 0 {
+		return
+	}
+
+	code := codeLines(d.lineContent)
+	for i, l := range d.lineContent {
+		if code[i] || moved[origins[i]] == nil {
+			continue
+		}
+
+		targets := moved[origins[i]]
+		s := string(l)
+		spans := inlineCodeSpans(s)
+		for _, re := range []*regexp.Regexp{linkTargetRegex, linkDefRegex, htmlLinkRegex} {
+			s = replaceTargets(s, re, spans, func(t string) string {
+				if to, ok := targets[strings.TrimPrefix(t, "#")]; ok && strings.HasPrefix(t, "#") {
+					return "#" + to
+				}
+				return t
+			})
+			spans = inlineCodeSpans(s)
+		}
+		d.lineContent[i] = []byte(s)
+	}
+}
+
+// explicitID returns the given heading line with an HTML anchor of the given
+// ID placed at the start of its text, replacing any it was given before.
+func explicitID(l []byte, id string) []byte {
+	s := string(l)
+	start := headingTextStart(s)
+	text := explicitIDRegex.ReplaceAllString(s[start:], "")
+	return []byte(fmt.Sprintf("%s<a id=\"%s\"></a>%s", s[:start], id, text))
+}
@@ -0,0 +1,69 @@
+package md
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var anchorsfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte("# Usage\n[example](#example)\n#include \"install.md\"\n#include \"build.md\"\n## Example")},
+	"install.md": &fstest.MapFile{Data: []byte(
+		"## Install\n### Example\nSee [the example](#example), [install](#install) and `[code](#example)`.\n\n[ref]: #example",
+	)},
+	"build.md":  &fstest.MapFile{Data: []byte("## Build\n### Example\n<a href=\"#example\">example</a>\n#include \"notes.md\"\n```\n[not a link](#example)\n```")},
+	"notes.md":  &fstest.MapFile{Data: []byte("#### Example\n[notes](#example)")},
+	"unique.md": &fstest.MapFile{Data: []byte("# Intro\n## Example\n## Example\n[second](#example-1)")},
+}
+
+func TestCollidingAnchorsAreMadeUnique(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", anchorsfs),
+		"# Usage\n[example](#example-3)\n"+
+			"## Install\n### Example\nSee [the example](#example), [install](#install) and `[code](#example)`.\n\n[ref]: #example\n"+
+			"## Build\n### <a id=\"example-1\"></a>Example\n<a href=\"#example-1\">example</a>\n"+
+			"#### <a id=\"example-2\"></a>Example\n[notes](#example-2)\n"+
+			"```\n[not a link](#example)\n```\n"+
+			"## <a id=\"example-3\"></a>Example\n",
+	)
+}
+
+func TestCollidingAnchorsAreMadeUniqueIdempotently(t *testing.T) {
+	is := is.New(t)
+
+	once := resolveAndWrite(is, "README.md", anchorsfs, WithMarkers())
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}}
+	for name, f := range anchorsfs {
+		if name != "README.md" {
+			rerunfs[name] = f
+		}
+	}
+
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers()), once)
+}
+
+func TestAnchorsWhichOnlyCollideWithinTheirOwnDocumentAreKept(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "unique.md", anchorsfs), "# Intro\n## Example\n## Example\n[second](#example-1)\n")
+}
+
+func TestCollidingAnchorsWarnings(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("README.md", anchorsfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.Configure(WithAnchors(WarnAnchors)))
+	is.NoErr(doc.ResolveIncludes(".", anchorsfs))
+
+	is.Equal(doc.Warnings(), []string{
+		`README.md: anchor #example of heading "Example" collides with an earlier heading, so is #example-3`,
+		`build.md: anchor #example of heading "Example" collides with an earlier heading, so is #example-1`,
+		`notes.md: anchor #example of heading "Example" collides with an earlier heading, so is #example-2`,
+	})
+}
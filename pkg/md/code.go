@@ -0,0 +1,128 @@
+package md
+
+import (
+	"bytes"
+	paths "path"
+	"strings"
+)
+
+var codeLanguages = map[string]string{
+	".c":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".cs":    "csharp",
+	".css":   "css",
+	".go":    "go",
+	".h":     "c",
+	".hpp":   "cpp",
+	".html":  "html",
+	".java":  "java",
+	".js":    "javascript",
+	".json":  "json",
+	".kt":    "kotlin",
+	".md":    "markdown",
+	".php":   "php",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".sh":    "sh",
+	".sql":   "sql",
+	".swift": "swift",
+	".toml":  "toml",
+	".ts":    "typescript",
+	".xml":   "xml",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+}
+
+var codeFileLanguages = map[string]string{
+	"Dockerfile": "dockerfile",
+	"Makefile":   "makefile",
+}
+
+// codeLanguage returns the language to tag a fenced code block
+// containing the file of the given name with.
+func codeLanguage(name string) string {
+	base := paths.Base(name)
+	if lang, ok := codeFileLanguages[base]; ok {
+		return lang
+	}
+
+	ext := strings.ToLower(paths.Ext(base))
+	if lang, ok := codeLanguages[ext]; ok {
+		return lang
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+// fenceCode wraps the document's content within a fenced code block tagged
+// with the given language, or the language of its file extension if none is
+// given. The fence is made long enough that no run of backticks within the
+// content can close it, and indentation common to every line is removed.
+func (d *Document) fenceCode(lang string) {
+	if len(lang) == 0 {
+		lang = codeLanguage(d.name)
+	}
+
+	lines := dedent(trimBlankLines(d.lineContent))
+
+	longest := 0
+	for _, l := range lines {
+		run := 0
+		for _, c := range l {
+			if c != '`' {
+				run = 0
+				continue
+			}
+			if run++; run > longest {
+				longest = run
+			}
+		}
+	}
+
+	fence := bytes.Repeat([]byte("`"), 3)
+	if longest >= 3 {
+		fence = bytes.Repeat([]byte("`"), longest+1)
+	}
+
+	content := make([][]byte, 0, len(lines)+2)
+	content = append(content, append(append([]byte{}, fence...), lang...))
+	content = append(content, lines...)
+	content = append(content, fence)
+
+	d.lineContent, d.lineNos = content, nil
+}
+
+func trimBlankLines(lines [][]byte) [][]byte {
+	for len(lines) > 0 && isBlank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && isBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// dedent removes the indentation common to all non blank lines.
+func dedent(lines [][]byte) [][]byte {
+	common := commonIndent(lines)
+	result := make([][]byte, len(lines))
+	for i, l := range lines {
+		if isBlank(l) {
+			result[i] = []byte{}
+			continue
+		}
+
+		w, n := 0, 0
+		for n < len(l) && w < common {
+			if l[n] == '\t' {
+				w += tabWidth - w%tabWidth
+			} else {
+				w++
+			}
+			n++
+		}
+		result[i] = l[n:]
+	}
+	return result
+}
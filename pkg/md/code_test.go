@@ -0,0 +1,52 @@
+package md
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var codefs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte("# Example\n#include-code \"pkg/md/example.go\" lines=3-5\nafter")},
+	"pkg/md/example.go": &fstest.MapFile{Data: []byte(
+		"package md\n\nfunc example() {\n\t// #include \"not/a/directive.md\"\n}\n",
+	)},
+	"backticks.md": &fstest.MapFile{Data: []byte("#include-code \"fences.md\"")},
+	"fences.md":    &fstest.MapFile{Data: []byte("\n    ```go\n    x := 1\n    ```\n\n")},
+	"region.md":    &fstest.MapFile{Data: []byte("#include-code \"script\" region=run lang=bash")},
+	"script": &fstest.MapFile{Data: []byte(
+		"#!/bin/sh\nif true; then\n    // region: run\n    echo run\n      echo indented\n    // endregion\nfi",
+	)},
+}
+
+func TestIncludeCodeFile(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", codefs),
+		"# Example\n```go\nfunc example() {\n\t// #include \"not/a/directive.md\"\n}\n```\nafter\n",
+	)
+}
+
+func TestIncludeCodeFenceOutgrowsBackticks(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "backticks.md", codefs), "````markdown\n```go\nx := 1\n```\n````\n")
+}
+
+func TestIncludeCodeRegionWithLanguage(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "region.md", codefs), "```bash\necho run\n  echo indented\n```\n")
+}
+
+func TestCodeLanguage(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(codeLanguage("main.go"), "go")
+	is.Equal(codeLanguage("scripts/build.PY"), "python")
+	is.Equal(codeLanguage("Dockerfile"), "dockerfile")
+	is.Equal(codeLanguage("config.hcl"), "hcl")
+	is.Equal(codeLanguage("LICENSE"), "")
+}
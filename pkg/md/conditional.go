@@ -0,0 +1,288 @@
+package md
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var conditionalRegex = regexp.MustCompile(`^#(if|ifdef|ifndef|elif|else|endif)(?:[ \t]+(.*?))?[ \t]*$`)
+
+// conditional is a block opened by an #if, #ifdef or #ifndef directive,
+// which only keeps the lines of the first of its branches to hold true.
+type conditional struct {
+	pos int
+	// active is set while within the branch being kept, and taken
+	// once any branch has been, so that no later branch is kept
+	active, taken bool
+	seenElse      bool
+}
+
+// conditionals is the stack of blocks the current line is nested within.
+type conditionals []conditional
+
+// active reports whether lines at the current point are to be kept.
+func (cs conditionals) active() bool {
+	for _, c := range cs {
+		if !c.active {
+			return false
+		}
+	}
+	return true
+}
+
+// enclosing returns the blocks which a conditional directive of the given
+// kind is nested within, being all of them for those opening a new block.
+func (cs conditionals) enclosing(kind string) conditionals {
+	switch kind {
+	case "if", "ifdef", "ifndef":
+		return cs
+	}
+	if len(cs) == 0 {
+		return cs
+	}
+	return cs[:len(cs)-1]
+}
+
+// applyConditional updates the stack for the given conditional directive found on
+// the line at pos, evaluating its condition against vars if it has one.
+func (d *Document) applyConditional(cs conditionals, pos int, m []string, vars map[string]string) (conditionals, error) {
+	kind, expr := m[1], strings.TrimSpace(m[2])
+	at := IncludeLink{d.displayKey(), d.srcLine(pos)}
+
+	switch kind {
+	case "if", "ifdef", "ifndef":
+		// conditions within blocks which are already being skipped are never evaluated
+		if !cs.active() {
+			return append(cs, conditional{pos: pos, taken: true}), nil
+		}
+		ok, err := evalCondition(kind, expr, vars)
+		if err != nil {
+			// the block is still opened, so its #endif isn't reported too
+			return append(cs, conditional{pos: pos, taken: true}), fmt.Errorf("%s: invalid #%s condition %q: %w", at, kind, expr, err)
+		}
+		return append(cs, conditional{pos: pos, active: ok, taken: ok}), nil
+	}
+
+	if len(cs) == 0 {
+		return cs, fmt.Errorf("%s: #%s without #if", at, kind)
+	}
+
+	c := &cs[len(cs)-1]
+	switch kind {
+	case "elif":
+		if c.seenElse {
+			return cs, fmt.Errorf("%s: #elif after #else", at)
+		}
+		if c.taken {
+			c.active = false
+			return cs, nil
+		}
+		ok, err := evalCondition("if", expr, vars)
+		if err != nil {
+			return cs, fmt.Errorf("%s: invalid #elif condition %q: %w", at, expr, err)
+		}
+		c.active, c.taken = ok, ok
+	case "else":
+		if c.seenElse {
+			return cs, fmt.Errorf("%s: #else after #else", at)
+		}
+		c.active, c.taken, c.seenElse = !c.taken, true, true
+	case "endif":
+		cs = cs[:len(cs)-1]
+	}
+	return cs, nil
+}
+
+// unclosed returns an error for each block which is missing its #endif.
+func (d *Document) unclosed(cs conditionals) []error {
+	errs := []error{}
+	for _, c := range cs {
+		errs = append(errs, fmt.Errorf("%s: #if without #endif", IncludeLink{d.displayKey(), d.srcLine(c.pos)}))
+	}
+	return errs
+}
+
+func evalCondition(kind, expr string, vars map[string]string) (bool, error) {
+	switch kind {
+	case "ifdef", "ifndef":
+		if !identRegex.MatchString(expr) {
+			return false, fmt.Errorf("must be a variable name")
+		}
+		_, ok := vars[expr]
+		return ok == (kind == "ifdef"), nil
+	}
+
+	if len(expr) == 0 {
+		return false, fmt.Errorf("missing condition")
+	}
+
+	toks, err := tokenize(expr)
+	if err != nil {
+		return false, err
+	}
+	p := condParser{toks: toks, vars: vars}
+	ok, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.toks) {
+		return false, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return ok, nil
+}
+
+var identRegex = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+type token struct {
+	text string
+	// literal is set for quoted strings, which are never variable names
+	literal bool
+}
+
+func tokenize(expr string) ([]token, error) {
+	toks := []token{}
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			toks = append(toks, token{text: expr[i : i+2]})
+			i += 2
+		case c == '(' || c == ')' || c == '!':
+			toks = append(toks, token{text: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, token{text: expr[i+1 : i+1+end], literal: true})
+			i += end + 2
+		default:
+			end := strings.IndexAny(expr[i:], " \t()!=&|\"'")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("unexpected %q", expr[i:])
+			}
+			toks = append(toks, token{text: expr[i : i+end]})
+			i += end
+		}
+	}
+	return toks, nil
+}
+
+// condParser evaluates conditions made up of variable names, which hold
+// true when defined as anything other than an empty string, 0 or false,
+// comparisons of variables with == and != against quoted strings, numbers
+// or other variables, defined(NAME), and any of those combined using !,
+// && and || along with parentheses.
+type condParser struct {
+	toks []token
+	pos  int
+	vars map[string]string
+}
+
+func (p *condParser) peek() string {
+	if p.pos >= len(p.toks) || p.toks[p.pos].literal {
+		return ""
+	}
+	return p.toks[p.pos].text
+}
+
+func (p *condParser) or() (bool, error) {
+	ok, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var rhs bool
+		rhs, err = p.and()
+		ok = ok || rhs
+	}
+	return ok, err
+}
+
+func (p *condParser) and() (bool, error) {
+	ok, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var rhs bool
+		rhs, err = p.unary()
+		ok = ok && rhs
+	}
+	return ok, err
+}
+
+func (p *condParser) unary() (bool, error) {
+	if p.peek() == "!" {
+		p.pos++
+		ok, err := p.unary()
+		return !ok, err
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() (bool, error) {
+	if p.pos >= len(p.toks) {
+		return false, fmt.Errorf("unexpected end of condition")
+	}
+
+	switch p.peek() {
+	case "(":
+		p.pos++
+		ok, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		if p.peek() != ")" {
+			return false, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return ok, nil
+	case "defined":
+		if p.pos+3 < len(p.toks) && p.toks[p.pos+1].text == "(" && p.toks[p.pos+3].text == ")" {
+			name := p.toks[p.pos+2].text
+			p.pos += 4
+			_, ok := p.vars[name]
+			return ok, nil
+		}
+		return false, fmt.Errorf("defined must be given a variable name, as defined(NAME)")
+	}
+
+	lhs, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+
+	switch op := p.peek(); op {
+	case "==", "!=":
+		p.pos++
+		rhs, err := p.operand()
+		if err != nil {
+			return false, err
+		}
+		return (lhs == rhs) == (op == "=="), nil
+	}
+	return lhs != "" && lhs != "0" && lhs != "false", nil
+}
+
+// operand returns the value of the variable the next token names, which is
+// empty if undefined, or the token itself if it's a literal of any kind.
+func (p *condParser) operand() (string, error) {
+	if p.pos >= len(p.toks) {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+
+	t := p.toks[p.pos]
+	if !t.literal && strings.ContainsAny(t.text, "()!=&|") {
+		return "", fmt.Errorf("unexpected %q", t.text)
+	}
+	p.pos++
+
+	if !t.literal && identRegex.MatchString(t.text) {
+		return p.vars[t.text], nil
+	}
+	return t.text, nil
+}
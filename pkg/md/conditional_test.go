@@ -0,0 +1,169 @@
+package md
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var conditionalfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte(
+		"# Widget\n" +
+			"#if EDITION == \"enterprise\"\n" +
+			"#include \"enterprise.md\"\n" +
+			"#define SUPPORT support@widget.com\n" +
+			"#elif EDITION == \"oss\"\n" +
+			"#include \"missing.md\"\n" +
+			"#else\n" +
+			"Community edition.\n" +
+			"#endif\n" +
+			"<!-- #ifndef SUPPORT -->\n" +
+			"Support is through the issue tracker.\n" +
+			"<!-- #else -->\n" +
+			"Contact ${SUPPORT}.\n" +
+			"<!-- #endif -->",
+	)},
+	"enterprise.md": &fstest.MapFile{Data: []byte("#ifdef BETA\nbeta ${UNDEFINED}\n#endif\nEnterprise edition.")},
+	"nested.md": &fstest.MapFile{Data: []byte(
+		"#if A\na\n#if B\nb\n#else\nnot b\n#endif\n#else\n#if B\nb only\n#endif\nnot a\n#endif",
+	)},
+	"code.md":       &fstest.MapFile{Data: []byte("```c\n#if DEBUG\n#endif\n```")},
+	"unclosed.md":   &fstest.MapFile{Data: []byte("# Title\n#if A\n#ifdef B\n#endif")},
+	"unopened.md":   &fstest.MapFile{Data: []byte("#endif\ntext\n#else")},
+	"elseelse.md":   &fstest.MapFile{Data: []byte("#if A\n#else\n#else\n#endif")},
+	"badcond.md":    &fstest.MapFile{Data: []byte("#if A ==\n#endif")},
+	"includesif.md": &fstest.MapFile{Data: []byte("#include \"opened.md\"")},
+	"opened.md":     &fstest.MapFile{Data: []byte("#if A\nopened")},
+}
+
+func TestConditionalBlocks(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", conditionalfs, WithDefines(map[string]string{"EDITION": "enterprise"})),
+		"# Widget\nEnterprise edition.\nContact support@widget.com.\n",
+	)
+	is.Equal(
+		resolveAndWrite(is, "README.md", conditionalfs),
+		"# Widget\nCommunity edition.\nSupport is through the issue tracker.\n",
+	)
+}
+
+func TestNestedConditionalBlocks(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "nested.md", conditionalfs, WithDefines(map[string]string{"A": "1", "B": "1"})), "a\nb\n")
+	is.Equal(resolveAndWrite(is, "nested.md", conditionalfs, WithDefines(map[string]string{"A": "1"})), "a\nnot b\n")
+	is.Equal(resolveAndWrite(is, "nested.md", conditionalfs, WithDefines(map[string]string{"A": "0", "B": "1"})), "b only\nnot a\n")
+}
+
+func TestConditionalDirectivesWithinCode(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "code.md", conditionalfs), "```c\n#if DEBUG\n#endif\n```\n")
+}
+
+func TestUnbalancedConditionalBlocks(t *testing.T) {
+	is := is.New(t)
+
+	tests := []struct {
+		doc    string
+		errors []string
+	}{
+		{doc: "unclosed.md", errors: []string{"unclosed.md:2: #if without #endif"}},
+		{doc: "unopened.md", errors: []string{"unopened.md:1: #endif without #if", "unopened.md:3: #else without #if"}},
+		{doc: "elseelse.md", errors: []string{"elseelse.md:3: #else after #else"}},
+		{doc: "badcond.md", errors: []string{`badcond.md:1: invalid #if condition "A =="`}},
+		{doc: "includesif.md", errors: []string{"opened.md:1: #if without #endif"}},
+	}
+
+	for _, tt := range tests {
+		doc, err := Open(tt.doc, conditionalfs)
+		is.NoErr(err)
+
+		err = doc.ResolveIncludes(".", conditionalfs)
+		doc.Close()
+		is.True(err != nil)
+		for _, e := range tt.errors {
+			is.True(strings.Contains(err.Error(), e)) // error for tt.doc is missing e
+		}
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	is := is.New(t)
+
+	vars := map[string]string{"A": "1", "EMPTY": "", "OFF": "false", "ED": "oss", "OTHER": "oss"}
+	tests := []struct {
+		kind, expr string
+		expected   bool
+	}{
+		{kind: "ifdef", expr: "EMPTY", expected: true},
+		{kind: "ifdef", expr: "MISSING", expected: false},
+		{kind: "ifndef", expr: "MISSING", expected: true},
+		{kind: "if", expr: "A", expected: true},
+		{kind: "if", expr: "EMPTY", expected: false},
+		{kind: "if", expr: "OFF", expected: false},
+		{kind: "if", expr: "MISSING", expected: false},
+		{kind: "if", expr: "0", expected: false},
+		{kind: "if", expr: "defined(EMPTY)", expected: true},
+		{kind: "if", expr: "!defined(MISSING) && A", expected: true},
+		{kind: "if", expr: `ED == "oss"`, expected: true},
+		{kind: "if", expr: `ED != 'oss'`, expected: false},
+		{kind: "if", expr: "ED == OTHER", expected: true},
+		{kind: "if", expr: "A == 1", expected: true},
+		{kind: "if", expr: "OFF || ED == \"ee\" || (A && !EMPTY)", expected: true},
+		{kind: "if", expr: "!(A && OFF)", expected: true},
+	}
+
+	for _, tt := range tests {
+		ok, err := evalCondition(tt.kind, tt.expr, vars)
+		is.NoErr(err)
+		is.Equal(ok, tt.expected)
+	}
+
+	for _, expr := range []string{"", "A ==", "(A", "A B", `"unterminated`, "defined(A", "A = B"} {
+		_, err := evalCondition("if", expr, vars)
+		is.True(err != nil)
+	}
+	_, err := evalCondition("ifdef", "A B", vars)
+	is.True(err != nil)
+}
+
+func TestConditionalBlocksWithMarkersAreEvaluatedAgain(t *testing.T) {
+	is := is.New(t)
+
+	enterprise := WithDefines(map[string]string{"EDITION": "enterprise"})
+	once := resolveAndWrite(is, "README.md", conditionalfs, WithMarkers(), enterprise)
+	is.Equal(
+		once,
+		"# Widget\n<!-- mdx:hidden \"#if EDITION == \\\"enterprise\\\"\" -->\n<!-- mdx:begin \"#if\" -->\n"+
+			"#include \"enterprise.md\"\n<!-- mdx:begin \"enterprise.md\" -->\nEnterprise edition.\n<!-- mdx:end \"enterprise.md\" -->\n"+
+			"<!-- mdx:hidden \"#define SUPPORT support@widget.com\" -->\n<!-- mdx:end \"#if\" -->\n"+
+			"<!-- mdx:hidden \"#elif EDITION == \\\"oss\\\"\" -->\n<!-- mdx:hidden \"#include \\\"missing.md\\\"\" -->\n"+
+			"<!-- mdx:hidden \"#else\" -->\n<!-- mdx:hidden \"Community edition.\" -->\n<!-- mdx:hidden \"#endif\" -->\n"+
+			"<!-- mdx:hidden \"<!-- #ifndef SUPPORT --\\x3e\" -->\n<!-- mdx:hidden \"Support is through the issue tracker.\" -->\n"+
+			"<!-- mdx:hidden \"<!-- #else --\\x3e\" -->\n<!-- mdx:begin \"#if\" -->\n"+
+			"<!-- mdx:hidden \"Contact ${SUPPORT}.\" -->\n<!-- mdx:begin \"${}\" -->\nContact support@widget.com.\n<!-- mdx:end \"${}\" -->\n"+
+			"<!-- mdx:end \"#if\" -->\n<!-- mdx:hidden \"<!-- #endif --\\x3e\" -->\n",
+	)
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}, "enterprise.md": conditionalfs["enterprise.md"]}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers(), enterprise), once)
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers()), resolveAndWrite(is, "README.md", conditionalfs, WithMarkers()))
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs), "# Widget\nCommunity edition.\nSupport is through the issue tracker.\n")
+}
+
+func TestNestedConditionalBlocksWithMarkers(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "nested.md", conditionalfs, WithMarkers(), WithDefines(map[string]string{"A": "0", "B": "1"})),
+		"<!-- mdx:hidden \"#if A\" -->\n<!-- mdx:hidden \"a\" -->\n<!-- mdx:hidden \"#if B\" -->\n<!-- mdx:hidden \"b\" -->\n<!-- mdx:hidden \"#else\" -->\n"+
+			"<!-- mdx:hidden \"not b\" -->\n<!-- mdx:hidden \"#endif\" -->\n<!-- mdx:hidden \"#else\" -->\n<!-- mdx:begin \"#if\" -->\n"+
+			"<!-- mdx:hidden \"#if B\" -->\n<!-- mdx:begin \"#if\" -->\nb only\n<!-- mdx:end \"#if\" -->\n<!-- mdx:hidden \"#endif\" -->\nnot a\n"+
+			"<!-- mdx:end \"#if\" -->\n<!-- mdx:hidden \"#endif\" -->\n",
+	)
+}
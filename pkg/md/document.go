@@ -14,6 +14,7 @@ import (
 	paths "path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +30,37 @@ type include struct {
 	parent  string
 	linePos int
 	doc     *Document
+	// end is the line position of the end marker of a block of previously
+	// resolved content directly following the directive, or 0 if none.
+	end int
+	// anchor is the heading anchor of the only section to include, if any
+	anchor string
+	attrs  map[string]string
+	// code is set for includes of source code to be fenced off
+	code bool
+	// vars are the variables defined at the point of the directive
+	vars map[string]string
+	// optional is set for includes which resolve to their fallback
+	// text, or nothing at all, rather than fail if they're missing
+	optional bool
+	// once is set for #include_once
+	once bool
+	// glob is the pattern given by the directive for includes
+	// expanded from it, which are already found at srcPath
+	glob    string
+	src     searchPath
+	srcPath string
+}
+
+// target returns the include's path as it was given in the directive.
+func (i include) target() string {
+	if len(i.glob) > 0 {
+		return i.glob
+	}
+	if len(i.anchor) == 0 {
+		return i.path
+	}
+	return i.path + "#" + i.anchor
 }
 
 type Document struct {
@@ -36,7 +68,137 @@ type Document struct {
 	name        string
 	r           io.ReadCloser
 	lineContent [][]byte
-	includes    []include
+	// lineNos maps each line to its line number within the original
+	// source, left nil for as long as no lines are removed
+	lineNos  []int
+	includes []include
+	// raw is set for documents whose content is included as is,
+	// without looking for any directives within it
+	raw  bool
+	opts options
+	// src is the file system the document was opened from, and
+	// fsPath the path to the document within it
+	src    searchPath
+	fsPath string
+	// key identifies the document amongst the others in its include chain
+	key string
+	// parent is the document which included this one, and includedAt the
+	// position of the directive within the parent which did so
+	parent     *Document
+	includedAt int
+	// vars are the variables defined before the start of the document
+	vars map[string]string
+	// frontMatter is the metadata the document starts with, if any
+	frontMatter *frontMatter
+	// once is set for documents marked with pragma once
+	once bool
+	// pinned is set for documents whose content has been verified against
+	// the hash they were pinned to, which every include within must be too
+	pinned bool
+	// mergedFrontMatter is the metadata within the front matter of included
+	// documents, to be merged into its own once resolved
+	mergedFrontMatter map[string]interface{}
+	// origins is the document each line of resolved content came from,
+	// left nil for as long as no includes have been resolved
+	origins  []*Document
+	warnings []string
+}
+
+// Option configures how a document resolves its includes.
+type Option func(*options)
+
+type options struct {
+	markers      bool
+	maxDepth     int
+	includePaths []string
+	delims       delims
+	offline      bool
+	// defines are the variables given up front, along with
+	// the environment's variables if environment is set
+	defines       map[string]string
+	environment   bool
+	keepUndefined bool
+	// remote is shared by every document within the tree, as are
+	// the includes emitted so far for those to be emitted only once
+	remote      *remoteFS
+	emitted     map[string]bool
+	frontMatter FrontMatterMode
+	anchors     AnchorMode
+	outputPath  string
+	// numberLevels are the range of heading levels numbered when numbering
+	numbering    bool
+	numberLevels [2]int
+	// output is where the resolved document is written to
+	output location
+}
+
+func (o options) directiveDelims() delims {
+	if len(o.delims.open) == 0 {
+		return defaultDelims
+	}
+	return o.delims
+}
+
+// WithDirectiveDelims sets the delimiters which directives can be wrapped within,
+// so that they can be hidden from view when the unresolved document is rendered.
+// By default directives can be wrapped within an HTML comment.
+func WithDirectiveDelims(open, close string) Option {
+	return func(o *options) {
+		o.delims = delims{open: open, close: close}
+	}
+}
+
+// WithIncludePaths adds directories to search for includes within, searched
+// in the order given after the lookup dir and the including document's own
+// directory have been.
+func WithIncludePaths(dirs ...string) Option {
+	return func(o *options) {
+		o.includePaths = append(o.includePaths, dirs...)
+	}
+}
+
+// WithOffline resolves remote includes from previously cached
+// copies only, failing for any which haven't been cached.
+func WithOffline() Option {
+	return func(o *options) {
+		o.offline = true
+	}
+}
+
+// DefaultMaxDepth is the maximum depth includes are nested to
+// before resolution fails, unless configured otherwise.
+const DefaultMaxDepth = 32
+
+// WithMaxDepth sets the maximum depth includes can be nested to before
+// resolution fails. A depth less than zero removes the limit entirely.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithMarkers keeps each include directive in the resolved document and
+// wraps the content it resolved to in begin/end marker comments, so the
+// document can be resolved again at a later date.
+func WithMarkers() Option {
+	return func(o *options) {
+		o.markers = true
+	}
+}
+
+// Configure applies the given options to the document and all of the
+// includes it goes on to resolve.
+func (d *Document) Configure(opts ...Option) error {
+	delims := d.opts.directiveDelims()
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+
+	// directives have to be looked for again within different delimiters
+	if d.opts.directiveDelims() != delims {
+		return d.scan()
+	}
+	return nil
 }
 
 func newFromFile(fd fs.File) (*Document, error) {
@@ -53,12 +215,35 @@ func newFromFile(fd fs.File) (*Document, error) {
 }
 
 func (d *Document) ResolveIncludes(path string, fsyses ...fs.FS) error {
+	if d.vars == nil {
+		d.vars = d.opts.initialVars()
+	}
+	if d.parent == nil {
+		d.opts.output = d.outputLocation()
+	}
+	if err := d.preprocess(d.vars); err != nil {
+		return err
+	}
+
+	// links are rewritten once the placeholders within them have been expanded,
+	// but before the content of includes, whose links are already rewritten
+	if !d.raw {
+		d.rewriteLinks(d.opts.output)
+	}
+
 	if len(d.includes) == 0 {
 		log.Printfln("[%s] no includes found", d.name)
-		return nil
+		return d.finish()
 	}
 
-	if err := d.openAllIncludes(resolveFS(path, fsyses)); err != nil {
+	if d.opts.remote == nil {
+		d.opts.remote = newRemoteFS(d.opts.offline)
+	}
+	if d.parent == nil {
+		d.opts.emitted = map[string]bool{}
+	}
+
+	if err := d.openAllIncludes(d.searchPaths(path, fsyses)); err != nil {
 		return err
 	}
 
@@ -70,49 +255,148 @@ func (d *Document) ResolveIncludes(path string, fsyses ...fs.FS) error {
 		return err
 	}
 
-	return nil
+	return d.finish()
 }
 
-func calcOffset(contentSize int) int {
-	if contentSize > 1 {
-		contentSize -= 1
+// finish makes the changes to the document being written out which can only
+// be made once everything within it has been resolved, leaving any documents
+// it includes as they are.
+func (d *Document) finish() error {
+	if d.parent != nil {
+		return nil
+	}
+
+	// headings are numbered first, for anchors and tables of contents to include their numbers
+	if err := d.numberSections(); err != nil {
+		return err
+	}
+	d.uniqueAnchors()
+	if err := d.expandTOCs(); err != nil {
+		return err
+	}
+
+	if len(d.mergedFrontMatter) > 0 {
+		return d.writeFrontMatter()
 	}
-	return contentSize
+	return nil
 }
 
 func (d *Document) addIncludesContentToDoc() error {
 	errs := errGroup{}
-	posOffset := 0
-	for _, incl := range d.includes {
-		if incl.doc == nil {
-			continue
+	content := make([][]byte, 0, len(d.lineContent))
+	origins := make([]*Document, 0, len(d.lineContent))
+	next := 0
+	for i := 0; i < len(d.lineContent); i++ {
+		// a directive can resolve to many includes, such as for glob patterns
+		group := []include{}
+		for next < len(d.includes) && d.includes[next].linePos == i+1 {
+			if d.includes[next].doc != nil {
+				group = append(group, d.includes[next])
+			}
+			next++
 		}
 
-		// recursively ensure sub includes children are resolved first
-		if err := incl.doc.addIncludesContentToDoc(); err != nil {
-			errs = append(errs, err)
+		if len(group) == 0 {
+			content = append(content, d.lineContent[i])
+			origins = append(origins, d)
 			continue
 		}
 
-		inclPos := incl.linePos + posOffset
-		content := [][]byte{}
-		content = append(content, d.lineContent[:inclPos-1]...)
-		content = append(content, incl.doc.lineContent...)
-		floorTopIndex := func(pos, size int) int {
-			if pos > size {
-				pos = size
+		// includes have already had their own includes resolved by this point
+		lines, lineOrigins := [][]byte{}, []*Document{}
+		for _, incl := range group {
+			if d.opts.frontMatter == MergeFrontMatter {
+				d.mergeFrontMatter(incl.doc)
+			}
+
+			l, err := d.includedContent(incl)
+			if err != nil {
+				errs = append(errs, err)
+				continue
 			}
-			return pos
+			lines = append(lines, l...)
+			lineOrigins = append(lineOrigins, incl.doc.lineOrigins(len(l))...)
 		}
-		content = append(content, d.lineContent[floorTopIndex(inclPos, len(d.lineContent)):]...)
 
-		d.lineContent = content
-		posOffset += calcOffset(len(incl.doc.lineContent))
+		// included content sits within the same list items and block quotes as its directive,
+		// taking the place of a directive which opens a list item, unless it's kept alongside markers
+		first, rest := listItemPrefixes(d.lineContent[i])
+		if d.opts.markers {
+			content = append(content, d.lineContent[i])
+			origins = append(origins, d)
+			lines = append([][]byte{beginMarker(group[0].target())}, append(lines, endMarker(group[0].target()))...)
+			lineOrigins = append([]*Document{d}, append(lineOrigins, d)...)
+			first = rest
+		}
+		if len(lines) > 0 {
+			content = append(content, prefixLines(lines[:1], first)...)
+			content = append(content, prefixLines(lines[1:], rest)...)
+		}
+		origins = append(origins, lineOrigins...)
+
+		// skip over content left behind by a previous resolution
+		if end := group[0].end; end > 0 {
+			i = end - 1
+		}
 	}
 
+	d.lineContent, d.origins = content, origins
 	return errs.toErrOrNil()
 }
 
+// lineOrigins returns the document each of the given number of lines of
+// this document's content came from, all being from this document unless
+// they're known to be from its includes.
+func (d *Document) lineOrigins(n int) []*Document {
+	if len(d.origins) == n {
+		return d.origins
+	}
+
+	origins := make([]*Document, n)
+	for i := range origins {
+		origins[i] = d
+	}
+	return origins
+}
+
+// includedContent returns the content of the given resolved include
+// as it should appear in place of its directive within this document.
+func (d *Document) includedContent(incl include) ([][]byte, error) {
+	lines := incl.doc.lineContent
+
+	if shift, ok := incl.attrs["shift"]; ok && !incl.code {
+		by, err := d.headingShift(incl, shift)
+		if err != nil {
+			return nil, err
+		}
+		lines = shiftHeadings(lines, by)
+	}
+
+	return lines, nil
+}
+
+// headingShift returns the amount given by a shift attribute to shift the
+// headings of an include by, which for auto is the amount which places them
+// directly below the closest heading preceding the include's directive.
+func (d *Document) headingShift(incl include, shift string) (int, error) {
+	if shift != "auto" {
+		by, err := strconv.Atoi(shift)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid heading shift %q, must be a number or auto", IncludeLink{d.displayKey(), d.srcLine(incl.linePos)}, shift)
+		}
+		return by, nil
+	}
+
+	below := 0
+	for _, h := range findHeadings(d.lineContent) {
+		if h.line >= incl.linePos-1 {
+			break
+		}
+		below = h.level
+	}
+	return autoShift(incl.doc.lineContent, below), nil
+}
+
 func (d *Document) Write(w io.Writer) (int, error) {
 	d.r.Close() // close original reader
 	var c int
@@ -153,10 +437,15 @@ func (d *Document) Close() error {
 
 func (d *Document) resolveIncludesIncludes(path string, fsyses ...fs.FS) error {
 	errs := errGroup{}
-	for _, incl := range d.includes {
+	for i, incl := range d.includes {
 		if incl.doc == nil {
 			continue
 		}
+		if d.skipOnce(incl) {
+			incl.doc.Close()
+			d.includes[i].doc = emptyDocument(incl.path)
+			continue
+		}
 		if err := incl.doc.ResolveIncludes(path, fsyses...); err != nil {
 			errs = append(errs, err)
 		}
@@ -164,62 +453,398 @@ func (d *Document) resolveIncludesIncludes(path string, fsyses ...fs.FS) error {
 	return errs.toErrOrNil()
 }
 
-func (d *Document) openAllIncludes(fsys fs.FS) error {
+// expandGlobs replaces each include with a glob pattern
+// for its path with an include for each file it matches.
+func (d *Document) expandGlobs(lookup []searchPath) error {
 	errs := errGroup{}
+	includes := make([]include, 0, len(d.includes))
+	for _, incl := range d.includes {
+		if len(incl.srcPath) > 0 || isRemote(incl.path) || !isGlob(incl.path) {
+			includes = append(includes, incl)
+			continue
+		}
+
+		expanded, err := d.expandGlob(incl, lookup)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		includes = append(includes, expanded...)
+	}
+
+	d.includes = includes
+	return errs.toErrOrNil()
+}
+
+func (d *Document) openAllIncludes(lookup []searchPath) error {
+	errs := errGroup{}
+	if err := d.expandGlobs(lookup); err != nil {
+		errs = append(errs, err)
+	}
+
 	for i := 0; i < len(d.includes); i++ {
 		ii := d.includes[i]
-		log.Printfln("[%s] opening include: %s", d.name, ii.path)
-		incl, err := Open(ii.path, fsys)
+		src, p := ii.src, ii.srcPath
+		if len(p) == 0 {
+			src, p = d.lookupInclude(ii.path, lookup)
+		}
+		// a pinned document could otherwise change what it includes at will
+		sum, pinned := ii.attrs["sha256"]
+		if d.pinned && !pinned {
+			errs = append(errs, fmt.Errorf("%s: unpinned include %s within a pinned document", IncludeLink{d.displayKey(), d.srcLine(ii.linePos)}, ii.path))
+			continue
+		}
+		if ii.optional && !exists(src.fsys, p) {
+			log.Printfln("[%s] skipping missing optional include: %s", d.name, ii.path)
+			d.includes[i].doc = fallback(ii)
+			continue
+		}
+		if err := d.checkIncludeChain(ii, src.key(p)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if pinned {
+			if err := verifyIntegrity(src.fsys, p, sum); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", IncludeLink{d.displayKey(), d.srcLine(ii.linePos)}, err))
+				continue
+			}
+		}
+
+		// only the document being written out keeps its markers
+		opts := d.opts
+		opts.markers = false
+
+		log.Printfln("[%s] opening include: %s from %s", d.name, ii.path, src.name)
+		incl, err := openFS(src, p, opts)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 
-		if err := incl.parse(); err != nil {
+		incl.parent = d
+		incl.includedAt = d.srcLine(ii.linePos)
+		incl.pinned = pinned
+		incl.vars = ii.vars
+
+		// source code never has any front matter, only content which looks like it
+		if ii.code {
+			incl.raw, incl.includes = true, []include{}
+		} else {
+			incl.readFrontMatter()
+		}
+
+		if err := incl.selectContent(ii); err != nil {
+			incl.Close()
 			errs = append(errs, err)
 			continue
 		}
 
+		if !ii.code {
+			if err := incl.applyParams(ii); err != nil {
+				incl.Close()
+				errs = append(errs, err)
+				continue
+			}
+			if d.opts.frontMatter != KeepFrontMatter {
+				incl.stripFrontMatter()
+			}
+		}
+
+		if ii.code {
+			incl.fenceCode(ii.attrs["lang"])
+		} else {
+			incl.once = incl.removePragmaOnce()
+		}
+
 		d.includes[i].doc = incl
 	}
 
 	return errs.toErrOrNil()
 }
 
+// fallback returns a document made up of nothing but the fallback text
+// of an optional include, which is empty if it wasn't given any.
+func fallback(incl include) *Document {
+	doc := emptyDocument(incl.path)
+	if text, ok := incl.attrs["fallback"]; ok {
+		doc.lineContent = splitLines([]byte(text))
+	}
+	return doc
+}
+
+// emptyDocument returns a document with no content at all.
+func emptyDocument(name string) *Document {
+	return &Document{name: name, raw: true, includes: []include{}, lineContent: [][]byte{}}
+}
+
+// IncludeLink is a single step within a chain of includes, the
+// document path and the line of the directive it continues on from.
+type IncludeLink struct {
+	Path string
+	Line int
+}
+
+func (l IncludeLink) String() string {
+	if l.Line == 0 {
+		return l.Path
+	}
+	return fmt.Sprintf("%s:%d", l.Path, l.Line)
+}
+
+func formatChain(chain []IncludeLink) string {
+	links := make([]string, len(chain))
+	for i, l := range chain {
+		links[i] = l.String()
+	}
+	return strings.Join(links, " -> ")
+}
+
+// IncludeCycleError is returned when an include leads back
+// to a document which is already part of its own include chain.
+type IncludeCycleError struct {
+	Chain []IncludeLink
+}
+
+func (e *IncludeCycleError) Error() string {
+	return fmt.Sprintf("include cycle detected: %s", formatChain(e.Chain))
+}
+
+// IncludeDepthError is returned when includes are nested
+// deeper than the configured maximum depth.
+type IncludeDepthError struct {
+	MaxDepth int
+	Chain    []IncludeLink
+}
+
+func (e *IncludeDepthError) Error() string {
+	return fmt.Sprintf("max include depth of %d exceeded: %s", e.MaxDepth, formatChain(e.Chain))
+}
+
+func (d *Document) displayKey() string {
+	if len(d.fsPath) > 0 {
+		return d.fsPath
+	}
+	return d.name
+}
+
+// includeChain returns the chain of includes from the root document
+// through to the given include of this document.
+func (d *Document) includeChain(incl include) []IncludeLink {
+	chain := []IncludeLink{{Path: d.displayKey(), Line: d.srcLine(incl.linePos)}}
+	for doc := d; doc.parent != nil; doc = doc.parent {
+		chain = append([]IncludeLink{{Path: doc.parent.displayKey(), Line: doc.includedAt}}, chain...)
+	}
+	return append(chain, IncludeLink{Path: incl.target()})
+}
+
+func (d *Document) checkIncludeChain(incl include, key string) error {
+	depth := 1
+	for doc := d; doc != nil; doc = doc.parent {
+		if len(doc.key) > 0 && doc.key == key {
+			return &IncludeCycleError{Chain: d.includeChain(incl)}
+		}
+		if doc.parent != nil {
+			depth++
+		}
+	}
+
+	maxDepth := d.opts.maxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if maxDepth > 0 && depth > maxDepth {
+		return &IncludeDepthError{MaxDepth: maxDepth, Chain: d.includeChain(incl)}
+	}
+
+	return nil
+}
+
 type errGroup []error
 
 func (e errGroup) toErrOrNil() error {
 	if len(e) > 0 {
-		buf := strings.Builder{}
-		buf.WriteString(fmt.Sprintf("%d errors occurred:\n", len(e)))
-		for _, err := range e {
-			buf.WriteString(fmt.Sprintf("\t* %v\n", err))
-		}
-		return errors.New(buf.String())
+		return groupError(e)
 	}
 	return nil
 }
 
+// groupError is the error produced by an errGroup, which errors.Is and errors.As
+// will see through to find any of the individual errors it is made up of.
+type groupError []error
+
+func (e groupError) Error() string {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("%d errors occurred:\n", len(e)))
+	for _, err := range e {
+		buf.WriteString(fmt.Sprintf("\t* %v\n", err))
+	}
+	return buf.String()
+}
+
+func (e groupError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e groupError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Document) parse() error {
 	errs := errGroup{}
 	readLineByLine(d.r, func(l []byte, pos int, e error) {
 		if e != nil {
 			errs = append(errs, e)
 		}
-		if path, ok := isInclude(string(l)); ok {
-			d.includes = append(d.includes, include{
-				path,
-				paths.Base(path),
-				d.name,
-				pos,
-				nil,
-			})
-		}
 		d.lineContent = append(d.lineContent, l)
 	})
+
+	if err := d.scan(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs.toErrOrNil()
+}
+
+// scan looks through the document's lines for its include directives.
+func (d *Document) scan() error {
+	errs := errGroup{}
+	d.includes = []include{}
+	delims := d.opts.directiveDelims()
+	code := codeLines(d.lineContent)
+	for i := 0; i < len(d.lineContent); i++ {
+		if code[i] {
+			continue
+		}
+
+		incl, ok := isInclude(delims.unwrap(string(d.lineContent[i])))
+		if !ok {
+			continue
+		}
+
+		incl.name = paths.Base(incl.path)
+		incl.parent = d.name
+		incl.linePos = i + 1
+
+		end, err := d.findEndMarker(i + 1)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if end > 0 {
+			incl.end = end
+			i = end - 1
+		}
+
+		d.includes = append(d.includes, incl)
+	}
+
 	return errs.toErrOrNil()
 }
 
+func splitAnchor(path string) (string, string) {
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+const (
+	beginMarkerPrefix  = "<!-- mdx:begin"
+	endMarkerPrefix    = "<!-- mdx:end"
+	hiddenMarkerPrefix = "<!-- mdx:hidden"
+)
+
+func beginMarker(path string) []byte {
+	return []byte(fmt.Sprintf("%s %q -->", beginMarkerPrefix, path))
+}
+
+func endMarker(path string) []byte {
+	return []byte(fmt.Sprintf("%s %q -->", endMarkerPrefix, path))
+}
+
+// hiddenMarker returns l hidden from view within a marker, so that it can be
+// brought back when the document is resolved again. The marker sits within
+// the same containers as l, and any --> within l is escaped so that it can't
+// close the marker's comment early.
+func hiddenMarker(l []byte) []byte {
+	return hiddenLines(containerPrefix(l), [][]byte{l})
+}
+
+// hiddenLines returns lines hidden from view within a single marker sitting
+// within the given containers, for blocks such as code which can't be hidden
+// line by line without their content escaping from the comment of each.
+func hiddenLines(prefix []byte, lines [][]byte) []byte {
+	blank := bytes.TrimRight(prefix, " \t")
+	content := make([][]byte, len(lines))
+	for i, l := range lines {
+		if bytes.HasPrefix(l, prefix) {
+			content[i] = l[len(prefix):]
+		} else {
+			content[i] = bytes.TrimPrefix(l, blank)
+		}
+	}
+
+	quoted := strings.ReplaceAll(strconv.Quote(string(bytes.Join(content, []byte("\n")))), "-->", `--\x3e`)
+	return append(append([]byte{}, prefix...), fmt.Sprintf("%s %s -->", hiddenMarkerPrefix, quoted)...)
+}
+
+// unhide returns the lines hidden within l if it's a hidden marker.
+func unhide(l []byte) ([][]byte, bool) {
+	m := strings.TrimSpace(string(stripContainers(l)))
+	if !strings.HasPrefix(m, hiddenMarkerPrefix+" ") || !strings.HasSuffix(m, " -->") {
+		return nil, false
+	}
+
+	hidden, err := strconv.Unquote(m[len(hiddenMarkerPrefix)+1 : len(m)-len(" -->")])
+	if err != nil {
+		return nil, false
+	}
+	return prefixLines(bytes.Split([]byte(hidden), []byte("\n")), containerPrefix(l)), true
+}
+
+// isMarkerOf reports whether l is the given marker, besides any leading
+// indentation or block quote markers.
+func isMarkerOf(l, marker []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(stripContainers(l)), marker)
+}
+
+func isMarker(l []byte, prefix string) bool {
+	return bytes.HasPrefix(stripContainers(l), []byte(prefix))
+}
+
+// findEndMarker returns the line position of the end marker closing the
+// block of resolved content starting at the given index, or 0 if the line
+// at that index does not open such a block.
+func (d *Document) findEndMarker(start int) (int, error) {
+	if start >= len(d.lineContent) || !isMarker(d.lineContent[start], beginMarkerPrefix) {
+		return 0, nil
+	}
+
+	depth := 0
+	for i := start; i < len(d.lineContent); i++ {
+		switch l := d.lineContent[i]; {
+		case isMarker(l, beginMarkerPrefix):
+			depth++
+		case isMarker(l, endMarkerPrefix):
+			depth--
+		}
+		if depth == 0 {
+			return i + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("[%s] unterminated include marker on line %d", d.name, start+1)
+}
+
 const backupFileHeaderMagic uint16 = 0x3532
 
 var tmpDir = func() string {
@@ -402,16 +1027,56 @@ func uint32ToBytes(n uint32) []byte {
 	return d
 }
 
+// Stdin is the document name which Open treats as a request
+// to read the document from standard input.
+const Stdin = "-"
+
+var stdin io.ReadCloser = os.Stdin
+
 func Open(name string, fsyses ...fs.FS) (*Document, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		logging.Error("unable to search for files relative to CWD: %v", err)
 		wd = "."
 	}
-	fsys := resolveFS(wd, fsyses)
-	fd, err := fsys.Open(name)
+
+	if name == Stdin {
+		doc := Document{name: "stdin", r: stdin, includes: []include{}}
+		doc.src, doc.fsPath = newSearchPath(wd), name
+		if err := doc.parse(); err != nil {
+			return nil, err
+		}
+		doc.readFrontMatter()
+		return &doc, nil
+	}
+
+	if len(fsyses) > 0 {
+		doc, err := openFS(searchPath{name: "fs[0]", fsys: fsyses[0]}, name, options{})
+		if err != nil {
+			return nil, err
+		}
+		doc.path = filepath.Join(wd, name)
+		doc.readFrontMatter()
+		return doc, nil
+	}
+
+	abs := name
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(wd, name)
+	}
+
+	doc, err := openFS(newSearchPath(filepath.Dir(abs)), filepath.Base(abs), options{})
 	if err != nil {
-		return nil, fmt.Errorf("%v: path: %s", err, name)
+		return nil, fmt.Errorf("%v: path: %s", errors.Unwrap(err), name)
+	}
+	doc.readFrontMatter()
+	return doc, nil
+}
+
+func openFS(src searchPath, name string, opts options) (*Document, error) {
+	fd, err := src.fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: path: %s", err, name)
 	}
 
 	doc, err := newFromFile(fd)
@@ -419,7 +1084,12 @@ func Open(name string, fsyses ...fs.FS) (*Document, error) {
 		return nil, err
 	}
 
-	doc.path = filepath.Join(wd, name)
+	doc.opts = opts
+	doc.src, doc.fsPath = src, name
+	doc.key = src.key(name)
+	if len(src.dir) > 0 {
+		doc.path = doc.key
+	}
 
 	if err := doc.parse(); err != nil {
 		return nil, err
@@ -428,26 +1098,171 @@ func Open(name string, fsyses ...fs.FS) (*Document, error) {
 	return doc, nil
 }
 
-const includeTokenDef = `\#include \"(\S+)\"`
+// searchPath is a file system includes are looked up within, along
+// with the directory it is rooted at if it is on disk.
+type searchPath struct {
+	name string
+	dir  string
+	fsys fs.FS
+}
 
-var includeRegexInst *regexp.Regexp
+func newSearchPath(dir string) searchPath {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return searchPath{name: dir, dir: dir, fsys: os.DirFS(dir)}
+}
+
+// searchPaths returns the paths includes are looked up within in order of
+// precedence, the given file systems or otherwise the lookup dir, followed
+// by any configured include paths.
+func (d *Document) searchPaths(dir string, fsyses []fs.FS) []searchPath {
+	sps := []searchPath{}
+	for i, fsys := range fsyses {
+		sps = append(sps, searchPath{name: fmt.Sprintf("fs[%d]", i), fsys: fsys})
+	}
+
+	if len(sps) == 0 {
+		sps = append(sps, newSearchPath(dir))
+	}
+
+	for _, ip := range d.opts.includePaths {
+		sps = append(sps, newSearchPath(ip))
+	}
+
+	return sps
+}
+
+// key returns a name which uniquely identifies the file at the given path.
+func (s searchPath) key(name string) string {
+	if len(s.dir) == 0 {
+		return name
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+func exists(fsys fs.FS, name string) bool {
+	if !fs.ValidPath(name) && !isRemote(name) {
+		return false
+	}
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+// lookupInclude returns the file system and path within it that an include
+// path refers to. Paths are looked up relative to the directory of this
+// document first, falling back to each of the search paths in order if
+// nothing exists there. Root anchored paths are only ever looked up from
+// the search paths. URLs, and paths within remote documents, are fetched.
+func (d *Document) lookupInclude(p string, lookup []searchPath) (searchPath, string) {
+	if isRemote(p) {
+		return d.opts.remote.searchPath(), p
+	}
+	if _, ok := d.src.fsys.(*remoteFS); ok {
+		if u, err := resolveURL(d.fsPath, p); err == nil {
+			return d.src, u
+		}
+	}
+
+	if d.src.fsys != nil && !strings.HasPrefix(p, "/") {
+		rel := paths.Join(paths.Dir(d.fsPath), p)
+		if exists(d.src.fsys, rel) {
+			return d.src, rel
+		}
+	}
+
+	p = paths.Clean(strings.TrimLeft(p, "/"))
+	for _, sp := range lookup {
+		if exists(sp.fsys, p) {
+			return sp, p
+		}
+	}
+
+	// nothing was found, so leave reporting that to the first search path
+	return lookup[0], p
+}
+
+const includeTokenDef = `\#include(-code|_once)?(\?)?[ \t]+\"([^"\s]+)\"((?:[ \t]+[\w-]+=(?:"[^"]*"|[^\s"]+))*)`
+
+const attrTokenDef = `([\w-]+)=(?:"([^"]*)"|([^\s"]+))`
+
+var includeRegexInst, attrRegexInst *regexp.Regexp
 
 func init() {
 	includeRegexInst = regexp.MustCompile(includeTokenDef)
+	attrRegexInst = regexp.MustCompile(attrTokenDef)
+}
+
+type delims struct {
+	open, close string
+}
+
+var defaultDelims = delims{open: "<!--", close: "-->"}
+
+// unwrap returns the text within the delimiters if l is made up of nothing
+// else, besides any leading indentation or block quote markers, otherwise
+// it returns l as is.
+func (dl delims) unwrap(l string) string {
+	content := strings.TrimSpace(strings.TrimLeft(l, " \t>"))
+	if len(content) < len(dl.open)+len(dl.close) || !strings.HasPrefix(content, dl.open) || !strings.HasSuffix(content, dl.close) {
+		return l
+	}
+	return strings.TrimSpace(content[len(dl.open) : len(content)-len(dl.close)])
+}
+
+// isInclude returns the include directive within l, such as #include "a.md" or
+// #include-code "main.go", along with any attributes given to it such as
+// lines=10-42, ignoring any directives within inline code spans. Directives
+// like #include? "a.md" or with optional=true are for optional includes, and
+// #include_once "a.md" for includes to be emitted only the first time.
+func isInclude(l string) (include, bool) {
+	m := includeMatch(l)
+	if m == nil {
+		return include{}, false
+	}
+
+	kind := ""
+	if m[2] >= 0 {
+		kind = l[m[2]:m[3]]
+	}
+
+	incl := include{path: l[m[6]:m[7]], attrs: parseAttrs(l[m[8]:m[9]]), code: kind == "-code", once: kind == "_once"}
+	incl.optional = m[4] >= 0 || incl.attrs["optional"] == "true"
+	if !incl.code {
+		incl.path, incl.anchor = splitAnchor(incl.path)
+	}
+	return incl, true
 }
 
-func isInclude(l string) (string, bool) {
-	matches := includeRegexInst.FindAllStringSubmatch(l, len(l)+1)
+// includeMatch returns the submatch indices of the first include
+// directive within l which isn't within an inline code span.
+func includeMatch(l string) []int {
+	matches := includeRegexInst.FindAllStringSubmatchIndex(l, -1)
 	if len(matches) == 0 {
-		return "", false
+		return nil
 	}
+
+	spans := inlineCodeSpans(l)
 	for _, m := range matches {
-		ms := len(m)
-		if ms > 1 {
-			return m[1], true
+		if len(m) < 10 || withinSpans(m[0], spans) {
+			continue
 		}
+		return m
 	}
-	return "", false
+	return nil
+}
+
+func parseAttrs(s string) map[string]string {
+	matches := attrRegexInst.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	attrs := map[string]string{}
+	for _, m := range matches {
+		attrs[m[1]] = m[2] + m[3]
+	}
+	return attrs
 }
 
 func readLineByLine(data io.Reader, eachLine func([]byte, int, error)) {
@@ -504,10 +1319,3 @@ func splitLines(b []byte) [][]byte {
 
 	return dest
 }
-
-func resolveFS(defaultRoot string, fsyses []fs.FS) fs.FS {
-	if len(fsyses) > 0 {
-		return fsyses[0]
-	}
-	return os.DirFS(defaultRoot)
-}
@@ -2,8 +2,12 @@ package md
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -73,6 +77,24 @@ func TestOpenNonExistantDoc(t *testing.T) {
 	is.Equal(err.Error(), "open doesnotexist.md: file does not exist: path: doesnotexist.md")
 }
 
+func TestOpenDocFromStdin(t *testing.T) {
+	is := is.New(t)
+
+	oldStdin := stdin
+	defer func() { stdin = oldStdin }()
+	stdin = io.NopCloser(strings.NewReader("# Piped document\n#include \"mddocsdir/othermarkdowndoc.md\""))
+
+	doc, err := Open(Stdin)
+	is.NoErr(err)
+	is.Equal(doc.name, "stdin")
+	is.Equal(len(doc.lineContent), 2)
+	is.Equal(len(doc.includes), 1)
+
+	is.NoErr(doc.ResolveIncludes(".", fsys))
+	is.Equal(string(mergeLines(doc.lineContent)), "# Piped document\n                     # A child markdown document called other")
+	is.NoErr(doc.Close())
+}
+
 func TestIncludesAreFoundInDocumentWithIncludes(t *testing.T) {
 	is := is.New(t)
 
@@ -115,6 +137,473 @@ func TestIncludesAreResolved(t *testing.T) {
 	is.NoErr(doc.ResolveIncludes("mddocsdir", fsys))
 }
 
+func resolveAndWrite(is *is.I, name string, fsys fs.FS, opts ...Option) string {
+	doc, err := Open(name, fsys)
+	is.NoErr(err)
+	defer doc.Close()
+
+	doc.Configure(opts...)
+	is.NoErr(doc.ResolveIncludes(".", fsys))
+
+	buf := bytes.Buffer{}
+	_, err = doc.Write(&buf)
+	is.NoErr(err)
+	return buf.String()
+}
+
+func TestIncludesAreResolvedWithMarkers(t *testing.T) {
+	is := is.New(t)
+
+	markersfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("# Title\n#include \"part.md\"\nfooter")},
+		"part.md":   &fstest.MapFile{Data: []byte("# Part\n#include \"sub.md\"")},
+		"sub.md":    &fstest.MapFile{Data: []byte("sub content")},
+	}
+
+	expected := "# Title\n" +
+		"#include \"part.md\"\n" +
+		"<!-- mdx:begin \"part.md\" -->\n" +
+		"# Part\n" +
+		"sub content\n" +
+		"<!-- mdx:end \"part.md\" -->\n" +
+		"footer\n"
+
+	out := resolveAndWrite(is, "README.md", markersfs, WithMarkers())
+	is.Equal(out, expected)
+
+	// resolving the output again must give back the same document
+	markersfs["README.md"] = &fstest.MapFile{Data: []byte(out)}
+	is.Equal(resolveAndWrite(is, "README.md", markersfs, WithMarkers()), expected)
+
+	// edits to fragments replace the previously resolved content
+	markersfs["sub.md"] = &fstest.MapFile{Data: []byte("edited sub content")}
+	is.Equal(resolveAndWrite(is, "README.md", markersfs, WithMarkers()), strings.Replace(expected, "sub content", "edited sub content", 1))
+
+	// without markers the previously resolved content is dropped along with the directive
+	is.Equal(resolveAndWrite(is, "README.md", markersfs), "# Title\n# Part\nedited sub content\nfooter\n")
+}
+
+func TestUnterminatedIncludeMarker(t *testing.T) {
+	is := is.New(t)
+
+	markersfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("#include \"part.md\"\n<!-- mdx:begin \"part.md\" -->\nstale")},
+	}
+
+	_, err := Open("README.md", markersfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "unterminated include marker on line 2"))
+}
+
+var cyclicfs = fstest.MapFS{
+	"a.md":     &fstest.MapFile{Data: []byte("# A\n\n#include \"b.md\"")},
+	"b.md":     &fstest.MapFile{Data: []byte("# B\n\n\n\n\n\n#include \"a.md\"")},
+	"self.md":  &fstest.MapFile{Data: []byte("#include \"self.md\"")},
+	"one.md":   &fstest.MapFile{Data: []byte("#include \"two.md\"")},
+	"two.md":   &fstest.MapFile{Data: []byte("#include \"three.md\"")},
+	"three.md": &fstest.MapFile{Data: []byte("# Three")},
+}
+
+func TestIncludeCycleIsDetected(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("a.md", cyclicfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", cyclicfs)
+	is.True(err != nil)
+
+	var cycleErr *IncludeCycleError
+	is.True(errors.As(err, &cycleErr))
+	is.Equal(cycleErr.Chain, []IncludeLink{{"a.md", 3}, {"b.md", 7}, {"a.md", 0}})
+	is.Equal(cycleErr.Error(), "include cycle detected: a.md:3 -> b.md:7 -> a.md")
+}
+
+func TestSelfIncludeIsDetected(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("self.md", cyclicfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	var cycleErr *IncludeCycleError
+	is.True(errors.As(doc.ResolveIncludes(".", cyclicfs), &cycleErr))
+	is.Equal(cycleErr.Error(), "include cycle detected: self.md:1 -> self.md")
+}
+
+func TestMaxIncludeDepth(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("one.md", cyclicfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	doc.Configure(WithMaxDepth(1))
+	var depthErr *IncludeDepthError
+	is.True(errors.As(doc.ResolveIncludes(".", cyclicfs), &depthErr))
+	is.Equal(depthErr.Error(), "max include depth of 1 exceeded: one.md:1 -> two.md:1 -> three.md")
+
+	doc, err = Open("one.md", cyclicfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	doc.Configure(WithMaxDepth(2))
+	is.NoErr(doc.ResolveIncludes(".", cyclicfs))
+	is.Equal(string(mergeLines(doc.lineContent)), "# Three")
+}
+
+func TestIncludesAreResolvedRelativeToIncludingDocument(t *testing.T) {
+	is := is.New(t)
+
+	relativefs := fstest.MapFS{
+		"README.md":           &fstest.MapFile{Data: []byte("#include \"docs/api/index.md\"")},
+		"docs/api/index.md":   &fstest.MapFile{Data: []byte("#include \"usage.md\"\n#include \"../shared/note.md\"\n#include \"/usage.md\"")},
+		"docs/api/usage.md":   &fstest.MapFile{Data: []byte("nested usage")},
+		"docs/shared/note.md": &fstest.MapFile{Data: []byte("shared note")},
+		"usage.md":            &fstest.MapFile{Data: []byte("root usage")},
+	}
+
+	is.Equal(resolveAndWrite(is, "README.md", relativefs), "nested usage\nshared note\nroot usage\n")
+}
+
+func TestIncludesFallbackToLookupDir(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("docwithincludes.md", fsys)
+	is.NoErr(err)
+	defer doc.Close()
+
+	// mddocsdir/multilineothermarkdowndoc.md includes a path given from the lookup dir
+	is.NoErr(doc.ResolveIncludes(".", fsys))
+	is.True(strings.Contains(string(mergeLines(doc.lineContent)), "A child markdown document called yet another"))
+}
+
+func TestIncludesAreSearchedForInOrder(t *testing.T) {
+	is := is.New(t)
+
+	docsfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("#include \"intro.md\"\n#include \"snippet.md\"")},
+		"intro.md":  &fstest.MapFile{Data: []byte("docs intro")},
+	}
+	snippetsfs := fstest.MapFS{
+		"intro.md":   &fstest.MapFile{Data: []byte("snippets intro")},
+		"snippet.md": &fstest.MapFile{Data: []byte("shared snippet")},
+	}
+
+	logs := bytes.Buffer{}
+	oldOutput, oldWriter := logging.OUTPUT, logging.WRITER
+	logging.OUTPUT, logging.WRITER = true, &logs
+	defer func() { logging.OUTPUT, logging.WRITER = oldOutput, oldWriter }()
+
+	doc, err := Open("README.md", docsfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.ResolveIncludes(".", docsfs, snippetsfs))
+	is.Equal(string(mergeLines(doc.lineContent)), "docs intro\nshared snippet")
+	is.True(strings.Contains(logs.String(), "[README.md] opening include: snippet.md from fs[1]"))
+}
+
+func TestIncludePathsOption(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	for _, d := range []string{"docs", "snippets"} {
+		is.NoErr(os.MkdirAll(filepath.Join(dir, d), os.ModePerm))
+	}
+	createTestMarkdownFile(is, filepath.Join(dir, "docs", "README.md"), []byte(`#include "shared.md"`))
+	createTestMarkdownFile(is, filepath.Join(dir, "snippets", "shared.md"), []byte("shared content"))
+
+	doc, err := Open(filepath.Join(dir, "docs", "README.md"))
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.True(doc.ResolveIncludes(filepath.Join(dir, "docs")) != nil) // not found without the include path
+
+	doc, err = Open(filepath.Join(dir, "docs", "README.md"))
+	is.NoErr(err)
+	defer doc.Close()
+
+	doc.Configure(WithIncludePaths(filepath.Join(dir, "snippets")))
+	is.NoErr(doc.ResolveIncludes(filepath.Join(dir, "docs")))
+	is.Equal(string(mergeLines(doc.lineContent)), "shared content")
+}
+
+var sectionsfs = fstest.MapFS{
+	"README.md":  &fstest.MapFile{Data: []byte("# Project\n#include \"other.md#installation\"\n# End")},
+	"missing.md": &fstest.MapFile{Data: []byte("#include \"other.md#uninstalling\"")},
+	"other.md": &fstest.MapFile{Data: []byte(
+		"# Other\nintro\n## Installation\nrun the installer\n### From source\n#include \"source.md\"\n## Usage\nuse it",
+	)},
+	"source.md": &fstest.MapFile{Data: []byte("go install ./...")},
+}
+
+func TestIncludeHeadingSection(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", sectionsfs),
+		"# Project\n## Installation\nrun the installer\n### From source\ngo install ./...\n# End\n",
+	)
+}
+
+func TestIncludeMissingHeadingSection(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("missing.md", sectionsfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", sectionsfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "anchor #uninstalling not found in other.md, available anchors: other, installation, from-source, usage"))
+}
+
+var snippetsfs = fstest.MapFS{
+	"snippets.md": &fstest.MapFile{Data: []byte(
+		"line 1\nline 2\nline 3\n<!-- region: install -->\ngo install ./...\n<!-- region: inner -->\ninner\n<!-- endregion -->\n<!-- endregion -->\nline 10",
+	)},
+	"main.go": &fstest.MapFile{Data: []byte("package main\n\n// region: main\nfunc main() {}\n// endregion\n")},
+}
+
+type selectContentTest struct {
+	title    string
+	include  string
+	expected string
+	err      string
+}
+
+var selectContentTests = []selectContentTest{
+	{
+		title:    "Range of lines",
+		include:  `#include "snippets.md" lines=2-3`,
+		expected: "line 2\nline 3\n",
+	},
+	{
+		title:    "Single line",
+		include:  `#include "snippets.md" lines=10`,
+		expected: "line 10\n",
+	},
+	{
+		title:    "Open ended range of lines",
+		include:  `#include "snippets.md" lines=-2`,
+		expected: "line 1\nline 2\n",
+	},
+	{
+		title:    "Range of lines is clamped to the end of the document",
+		include:  `#include "snippets.md" lines=9-20`,
+		expected: "<!-- endregion -->\nline 10\n",
+	},
+	{
+		title:   "Backwards range of lines",
+		include: `#include "snippets.md" lines=3-2`,
+		err:     `invalid line range "3-2" for snippets.md`,
+	},
+	{
+		title:    "Named region with nested region markers removed",
+		include:  `#include "snippets.md" region=install`,
+		expected: "go install ./...\ninner\n",
+	},
+	{
+		title:    "Nested named region",
+		include:  `#include "snippets.md" region="inner"`,
+		expected: "inner\n",
+	},
+	{
+		title:    "Named region within code",
+		include:  `#include "main.go" region=main`,
+		expected: "func main() {}\n",
+	},
+	{
+		title:   "Missing named region",
+		include: `#include "snippets.md" region=uninstall`,
+		err:     "region uninstall not found in snippets.md, available regions: install, inner",
+	},
+}
+
+func TestTableForSelectingIncludedContent(t *testing.T) {
+	for _, tt := range selectContentTests {
+		t.Run(tt.title, func(t *testing.T) {
+			is := is.New(t)
+
+			docfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(tt.include)}}
+			for name, f := range snippetsfs {
+				docfs[name] = f
+			}
+
+			if len(tt.err) == 0 {
+				is.Equal(resolveAndWrite(is, "README.md", docfs), tt.expected)
+				return
+			}
+
+			doc, err := Open("README.md", docfs)
+			is.NoErr(err)
+			defer doc.Close()
+
+			err = doc.ResolveIncludes(".", docfs)
+			is.True(err != nil)
+			is.True(strings.Contains(err.Error(), tt.err))
+		})
+	}
+}
+
+var shiftfs = fstest.MapFS{
+	"README.md":  &fstest.MapFile{Data: []byte("# Project\n## Guides\n#include \"guide.md\" shift=auto\n#include \"guide.md\" shift=+1")},
+	"invalid.md": &fstest.MapFile{Data: []byte("#include \"guide.md\" shift=nope")},
+	"guide.md":   &fstest.MapFile{Data: []byte("# Guide\nSteps\n-----\n#include \"step.md\" shift=auto")},
+	"step.md":    &fstest.MapFile{Data: []byte("# Step one")},
+}
+
+func TestIncludeHeadingShift(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", shiftfs),
+		"# Project\n## Guides\n### Guide\n#### Steps\n##### Step one\n"+
+			"## <a id=\"guide-1\"></a>Guide\n### <a id=\"steps-1\"></a>Steps\n#### <a id=\"step-one-1\"></a>Step one\n",
+	)
+
+	doc, err := Open("invalid.md", shiftfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", shiftfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), `invalid.md:1: invalid heading shift "nope"`))
+}
+
+var delimsfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte("# Title\n<!--#include \"part.md\" lines=2-->\n  > <!-- #include \"part.md\" lines=1 -->")},
+	"custom.md": &fstest.MapFile{Data: []byte("{%#include \"part.md\" lines=2%}")},
+	"part.md":   &fstest.MapFile{Data: []byte("first\nsecond")},
+}
+
+func TestIncludeWithinCommentDelims(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "README.md", delimsfs), "# Title\nsecond\n  > first\n")
+}
+
+func TestIncludeWithinCustomDelims(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("custom.md", delimsfs)
+	is.NoErr(err)
+	defer doc.Close()
+	is.Equal(doc.includes[0].attrs["lines"], "2%}")
+
+	is.NoErr(doc.Configure(WithDirectiveDelims("{%", "%}")))
+	is.Equal(len(doc.includes), 1)
+	is.Equal(doc.includes[0].attrs["lines"], "2")
+
+	is.NoErr(doc.ResolveIncludes(".", delimsfs))
+	is.Equal(string(mergeLines(doc.lineContent)), "second")
+}
+
+var optionalfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte(
+		"# Title\n#include? \"local-notes.md\"\n#include \"missing.md\" optional=true fallback=\"_No notes yet._\"\n#include? \"notes.md\"\n#include? \"drafts/*.md\"\nend",
+	)},
+	"notes.md":    &fstest.MapFile{Data: []byte("notes")},
+	"required.md": &fstest.MapFile{Data: []byte("#include \"local-notes.md\"")},
+}
+
+func TestOptionalIncludes(t *testing.T) {
+	is := is.New(t)
+
+	logs := bytes.Buffer{}
+	oldOutput, oldWriter := logging.OUTPUT, logging.WRITER
+	logging.OUTPUT, logging.WRITER = true, &logs
+	defer func() { logging.OUTPUT, logging.WRITER = oldOutput, oldWriter }()
+
+	is.Equal(resolveAndWrite(is, "README.md", optionalfs), "# Title\n_No notes yet._\nnotes\nend\n")
+	is.True(strings.Contains(logs.String(), "[README.md] skipping missing optional include: local-notes.md"))
+	is.True(strings.Contains(logs.String(), "[README.md] skipping missing optional include: drafts/*.md"))
+}
+
+func TestOptionalIncludesWithMarkers(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", optionalfs, WithMarkers()),
+		"# Title\n"+
+			"#include? \"local-notes.md\"\n<!-- mdx:begin \"local-notes.md\" -->\n<!-- mdx:end \"local-notes.md\" -->\n"+
+			"#include \"missing.md\" optional=true fallback=\"_No notes yet._\"\n<!-- mdx:begin \"missing.md\" -->\n_No notes yet._\n<!-- mdx:end \"missing.md\" -->\n"+
+			"#include? \"notes.md\"\n<!-- mdx:begin \"notes.md\" -->\nnotes\n<!-- mdx:end \"notes.md\" -->\n"+
+			"#include? \"drafts/*.md\"\n<!-- mdx:begin \"drafts/*.md\" -->\n<!-- mdx:end \"drafts/*.md\" -->\n"+
+			"end\n",
+	)
+}
+
+func TestRequiredIncludeIsMissing(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("required.md", optionalfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.True(doc.ResolveIncludes(".", optionalfs) != nil)
+}
+
+var indentfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte(
+		"- Steps\n  1. First\n     #include \"step.md\"\n> Note\n> > #include \"note.md\"\n\t#include-code \"run.sh\"",
+	)},
+	"step.md":   &fstest.MapFile{Data: []byte("Do this.\n\n```sh\nmake\n```")},
+	"note.md":   &fstest.MapFile{Data: []byte("quoted\n\n#include \"nested.md\"")},
+	"nested.md": &fstest.MapFile{Data: []byte("  nested")},
+	"run.sh":    &fstest.MapFile{Data: []byte("./run")},
+}
+
+func TestIncludedContentInheritsDirectiveIndentation(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", indentfs),
+		"- Steps\n  1. First\n     Do this.\n\n     ```sh\n     make\n     ```\n> Note\n> > quoted\n> >\n> >   nested\n\t```sh\n\t./run\n\t```\n",
+	)
+}
+
+var listitemfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte("- one\n- #include \"two.md\"\n- three\n> 10. <!-- #include \"two.md\" -->")},
+	"two.md":    &fstest.MapFile{Data: []byte("two\n\n  indented")},
+}
+
+func TestIncludeAsListItem(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", listitemfs),
+		"- one\n- two\n\n    indented\n- three\n> 10. two\n>\n>       indented\n",
+	)
+	resolved := resolveAndWrite(is, "README.md", listitemfs, WithMarkers())
+	is.Equal(
+		resolved,
+		"- one\n- #include \"two.md\"\n  <!-- mdx:begin \"two.md\" -->\n  two\n\n    indented\n  <!-- mdx:end \"two.md\" -->\n- three\n"+
+			"> 10. <!-- #include \"two.md\" -->\n>     <!-- mdx:begin \"two.md\" -->\n>     two\n>\n>       indented\n>     <!-- mdx:end \"two.md\" -->\n",
+	)
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(resolved)}, "two.md": listitemfs["two.md"]}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers()), resolved)
+}
+
+func TestIndentedIncludesWithMarkers(t *testing.T) {
+	is := is.New(t)
+
+	resolved := resolveAndWrite(is, "README.md", indentfs, WithMarkers())
+	is.True(strings.Contains(resolved, "> > #include \"note.md\"\n> > <!-- mdx:begin \"note.md\" -->\n> > quoted\n"))
+
+	// resolving the document again leaves it as it is
+	doc, err := Open("README.md", fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(resolved)}})
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.Configure(WithMarkers()))
+	is.NoErr(doc.ResolveIncludes(".", indentfs))
+	is.Equal(string(mergeLines(doc.lineContent))+"\n", resolved)
+}
+
 func TestWritingBackupDocumentHeader(t *testing.T) {
 	is := is.New(t)
 
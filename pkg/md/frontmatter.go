@@ -0,0 +1,230 @@
+package md
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/tauraamui/imdclude/pkg/logging"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	yamlFrontMatterDelim = "---"
+	tomlFrontMatterDelim = "+++"
+)
+
+// FrontMatterMode is how the front matter of included documents is handled.
+type FrontMatterMode int
+
+const (
+	// StripFrontMatter removes the front matter of included documents.
+	StripFrontMatter FrontMatterMode = iota
+	// KeepFrontMatter includes the front matter of included documents as is.
+	KeepFrontMatter
+	// MergeFrontMatter removes the front matter of included documents, adding
+	// any keys within it to the front matter of the document being resolved.
+	MergeFrontMatter
+)
+
+// WithFrontMatter sets how the front matter of included documents is handled,
+// which by default is to remove it.
+func WithFrontMatter(mode FrontMatterMode) Option {
+	return func(o *options) {
+		o.frontMatter = mode
+	}
+}
+
+// FrontMatter returns the metadata within the document's front matter,
+// or nil if it doesn't have any.
+func (d *Document) FrontMatter() map[string]interface{} {
+	if d.frontMatter == nil {
+		return nil
+	}
+	return d.frontMatter.data
+}
+
+// readFrontMatter reads the metadata within the document's front matter,
+// leaving content which only looks like front matter, such as a thematic
+// break at the very start followed by another later on, as part of its content.
+func (d *Document) readFrontMatter() {
+	fm, err := parseFrontMatter(d.lineContent)
+	if err != nil {
+		log.Printfln("[%s] treating front matter as content: %v", d.name, err)
+		return
+	}
+	d.frontMatter = fm
+}
+
+// stripFrontMatter removes the lines of the document's front matter.
+func (d *Document) stripFrontMatter() {
+	if d.frontMatter == nil {
+		return
+	}
+	size := d.frontMatter.size
+	d.filterLines(func(i int) bool { return d.srcLine(i+1) > size })
+}
+
+// mergeFrontMatter adds the front matter of an included document, along with
+// that merged into it from its own includes, to that merged into this document,
+// other than the params only describing included partials.
+func (d *Document) mergeFrontMatter(incl *Document) {
+	if d.mergedFrontMatter == nil {
+		d.mergedFrontMatter = map[string]interface{}{}
+	}
+
+	if incl.frontMatter != nil {
+		data := map[string]interface{}{}
+		for k, v := range incl.frontMatter.data {
+			if k != "params" {
+				data[k] = v
+			}
+		}
+		mergeData(d.mergedFrontMatter, data)
+	}
+	mergeData(d.mergedFrontMatter, incl.mergedFrontMatter)
+}
+
+// writeFrontMatter replaces the document's front matter with itself merged
+// with that of its includes. It's read again from the resolved document, for
+// any changes since it was opened, such as expanded placeholders, to be kept.
+func (d *Document) writeFrontMatter() error {
+	fm, err := parseFrontMatter(d.lineContent)
+	if err != nil {
+		log.Printfln("[%s] treating front matter as content: %v", d.name, err)
+	}
+	if fm == nil {
+		fm = &frontMatter{delim: yamlFrontMatterDelim, data: map[string]interface{}{}}
+	}
+	if !fm.merge(d.mergedFrontMatter) {
+		return nil
+	}
+
+	lines, err := fm.encode()
+	if err != nil {
+		return fmt.Errorf("%s: unable to write merged front matter: %w", d.displayKey(), err)
+	}
+	d.lineContent = append(lines, d.lineContent[fm.size:]...)
+	fm.size = len(lines)
+	d.frontMatter = fm
+	return nil
+}
+
+// frontMatter is the block of YAML or TOML metadata
+// which a document can start with.
+type frontMatter struct {
+	delim string
+	// size is the number of lines the front matter takes
+	// up within the document, its delimiters included
+	size int
+	data map[string]interface{}
+}
+
+// frontMatterSize returns the number of lines the front matter at the start
+// of lines takes up, its delimiters included, or 0 if there isn't any.
+func frontMatterSize(lines [][]byte) int {
+	if len(lines) == 0 {
+		return 0
+	}
+
+	delim := string(bytes.TrimRight(lines[0], " \t"))
+	if delim != yamlFrontMatterDelim && delim != tomlFrontMatterDelim {
+		return 0
+	}
+
+	for i := 1; i < len(lines); i++ {
+		end := string(bytes.TrimRight(lines[i], " \t"))
+		if end == delim || (delim == yamlFrontMatterDelim && end == "...") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// parseFrontMatter returns the front matter at the start of lines,
+// or nil if lines don't start with any.
+func parseFrontMatter(lines [][]byte) (*frontMatter, error) {
+	size := frontMatterSize(lines)
+	if size == 0 {
+		return nil, nil
+	}
+
+	delim := string(bytes.TrimRight(lines[0], " \t"))
+	fm := frontMatter{delim: delim, size: size, data: map[string]interface{}{}}
+	content := mergeLines(lines[1 : size-1])
+	var err error
+	if delim == yamlFrontMatterDelim {
+		err = yaml.Unmarshal(content, &fm.data)
+	} else {
+		err = toml.Unmarshal(content, &fm.data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+	if fm.data == nil {
+		fm.data = map[string]interface{}{}
+	}
+	return &fm, nil
+}
+
+// encode returns the lines of the front matter, delimiters included.
+func (fm *frontMatter) encode() ([][]byte, error) {
+	buf := bytes.Buffer{}
+	if fm.delim == tomlFrontMatterDelim {
+		if err := toml.NewEncoder(&buf).Encode(fm.data); err != nil {
+			return nil, err
+		}
+	} else {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(fm.data); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := [][]byte{[]byte(fm.delim)}
+	lines = append(lines, splitLines(bytes.TrimRight(buf.Bytes(), "\n"))...)
+	return append(lines, []byte(fm.delim)), nil
+}
+
+// merge adds each key of other's front matter missing from this
+// front matter to it, merging the tables both have recursively.
+func (fm *frontMatter) merge(other map[string]interface{}) bool {
+	return mergeData(fm.data, other)
+}
+
+func mergeData(dst, src map[string]interface{}) bool {
+	changed := false
+	for k, v := range src {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			changed = true
+			continue
+		}
+
+		dm, dok := existing.(map[string]interface{})
+		sm, sok := v.(map[string]interface{})
+		if dok && sok && mergeData(dm, sm) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// number returns the value of the given key as a number, if it is one.
+func (fm *frontMatter) number(key string) (float64, bool) {
+	if fm == nil {
+		return 0, false
+	}
+
+	switch n := fm.data[key].(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,138 @@
+package md
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var frontmatterfs = fstest.MapFS{
+	"index.md": &fstest.MapFile{Data: []byte(
+		"---\ntitle: Handbook\nsite:\n  theme: dark\n---\n# Handbook\n#include \"pages/intro.md\"\n#include \"pages/setup.md\"",
+	)},
+	"pages/intro.md": &fstest.MapFile{Data: []byte(
+		"---\ntitle: Intro\ntags: [intro]\nsite:\n  theme: light\n  lang: en\n---\n## Intro\n#include \"../partial.md\" name=\"x\"",
+	)},
+	"pages/setup.md": &fstest.MapFile{Data: []byte("+++\nweight = 2\nauthor = \"sam\"\n+++\n## Setup")},
+	"partial.md":     &fstest.MapFile{Data: []byte("---\nparams:\n  name:\n---\n{{name}}")},
+	"plain.md":       &fstest.MapFile{Data: []byte("#include \"pages/setup.md\"")},
+	"toml.md":        &fstest.MapFile{Data: []byte("+++\ntitle = \"Root\"\n+++\n#include \"pages/setup.md\"")},
+	"section.md":     &fstest.MapFile{Data: []byte("#include \"pages/intro.md#intro\" shift=1")},
+}
+
+func TestFrontMatterIsStripped(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "index.md", frontmatterfs),
+		"---\ntitle: Handbook\nsite:\n  theme: dark\n---\n# Handbook\n## Intro\nx\n## Setup\n",
+	)
+	is.Equal(resolveAndWrite(is, "section.md", frontmatterfs), "### Intro\nx\n")
+}
+
+func TestFrontMatterIsKept(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "plain.md", frontmatterfs, WithFrontMatter(KeepFrontMatter)),
+		"+++\nweight = 2\nauthor = \"sam\"\n+++\n## Setup\n",
+	)
+}
+
+func TestFrontMatterIsMerged(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "index.md", frontmatterfs, WithFrontMatter(MergeFrontMatter)),
+		"---\nauthor: sam\nsite:\n  lang: en\n  theme: dark\ntags:\n  - intro\ntitle: Handbook\nweight: 2\n---\n# Handbook\n## Intro\nx\n## Setup\n",
+	)
+	is.Equal(
+		resolveAndWrite(is, "plain.md", frontmatterfs, WithFrontMatter(MergeFrontMatter)),
+		"---\nauthor: sam\nweight: 2\n---\n## Setup\n",
+	)
+	is.Equal(
+		resolveAndWrite(is, "toml.md", frontmatterfs, WithFrontMatter(MergeFrontMatter)),
+		"+++\nauthor = \"sam\"\ntitle = \"Root\"\nweight = 2\n+++\n## Setup\n",
+	)
+}
+
+func TestMergedFrontMatterIsReadAgainOnceExpanded(t *testing.T) {
+	is := is.New(t)
+
+	mergefs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("---\ntitle: ${T}\n---\n#include \"tagged.md\"")},
+		"tagged.md": &fstest.MapFile{Data: []byte("---\ntags: [a]\n---\nbody")},
+	}
+	defines := WithDefines(map[string]string{"T": "hello"})
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", mergefs, WithFrontMatter(MergeFrontMatter), defines),
+		"---\ntags:\n  - a\ntitle: hello\n---\nbody\n",
+	)
+
+	once := resolveAndWrite(is, "README.md", mergefs, WithFrontMatter(MergeFrontMatter), WithMarkers(), defines)
+	is.Equal(once, "---\ntags:\n  - a\ntitle: hello\n---\n#include \"tagged.md\"\n<!-- mdx:begin \"tagged.md\" -->\nbody\n<!-- mdx:end \"tagged.md\" -->\n")
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}, "tagged.md": mergefs["tagged.md"]}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithFrontMatter(MergeFrontMatter), WithMarkers(), defines), once)
+}
+
+func TestFrontMatterAccessor(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("index.md", frontmatterfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.Equal(doc.FrontMatter()["title"], "Handbook")
+	is.Equal(doc.FrontMatter()["site"], map[string]interface{}{"theme": "dark"})
+
+	doc, err = Open("plain.md", frontmatterfs)
+	is.NoErr(err)
+	defer doc.Close()
+	is.True(doc.FrontMatter() == nil)
+}
+
+func TestInvalidFrontMatter(t *testing.T) {
+	is := is.New(t)
+
+	badfs := fstest.MapFS{
+		"bad.md":      &fstest.MapFile{Data: []byte("---\na: b: c\n---\ntext")},
+		"break.md":    &fstest.MapFile{Data: []byte("---\nA fragment between breaks.\n\n---")},
+		"includes.md": &fstest.MapFile{Data: []byte("#include \"bad.md\"\n#include \"break.md\"")},
+	}
+
+	// a document which opens with a thematic break rather than front matter is left as it is
+	doc, err := Open("bad.md", badfs)
+	is.NoErr(err)
+	defer doc.Close()
+	is.True(doc.FrontMatter() == nil)
+	is.Equal(resolveAndWrite(is, "bad.md", badfs, WithFrontMatter(MergeFrontMatter)), "---\na: b: c\n---\ntext\n")
+
+	// as is an included one
+	is.Equal(
+		resolveAndWrite(is, "includes.md", badfs),
+		"---\na: b: c\n---\ntext\n---\nA fragment between breaks.\n\n---\n",
+	)
+}
+
+func TestFrontMatterIsNotReadFromCodeIncludes(t *testing.T) {
+	is := is.New(t)
+
+	codefs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("#include-code \"list.yaml\"")},
+		"list.yaml": &fstest.MapFile{Data: []byte("---\n- a\n---\n- b")},
+	}
+
+	is.Equal(resolveAndWrite(is, "README.md", codefs), "```yaml\n---\n- a\n---\n- b\n```\n")
+}
+
+func TestHeadingsExcludeFrontMatter(t *testing.T) {
+	is := is.New(t)
+
+	headings := findHeadings(splitLines([]byte("---\ntitle: x\n---\n# Heading\ntext\n---")))
+	is.Equal(len(headings), 2)
+	is.Equal(headings[0].text, "Heading")
+	is.Equal(headings[1].text, "text")
+}
@@ -0,0 +1,257 @@
+package md
+
+import (
+	"fmt"
+	"io/fs"
+	paths "path"
+	"sort"
+	"strings"
+	"unicode"
+
+	log "github.com/tauraamui/imdclude/pkg/logging"
+)
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// glob returns the names of all files within fsys matching pattern, which on
+// top of the syntax supported by fs.Glob can contain ** to match any number
+// of directories.
+func glob(fsys fs.FS, pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		return onlyFiles(fsys, matches), nil
+	}
+
+	segments := strings.Split(pattern, "/")
+	root := []string{}
+	for _, s := range segments {
+		if isGlob(s) {
+			break
+		}
+		root = append(root, s)
+	}
+
+	start := "."
+	if len(root) > 0 {
+		start = strings.Join(root, "/")
+	}
+
+	matches := []string{}
+	err := fs.WalkDir(fsys, start, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if p == start && len(root) > 0 {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+
+		ok, err := matchSegments(segments, strings.Split(p, "/"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func onlyFiles(fsys fs.FS, names []string) []string {
+	files := []string{}
+	for _, n := range names {
+		if fi, err := fs.Stat(fsys, n); err == nil && !fi.IsDir() {
+			files = append(files, n)
+		}
+	}
+	return files
+}
+
+// matchSegments reports whether the segments of a path match those of a
+// pattern, where a ** pattern segment matches zero or more path segments.
+func matchSegments(pattern, name []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(name) == 0, nil
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if ok, err := matchSegments(pattern[1:], name[i:]); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	if len(name) == 0 {
+		return false, nil
+	}
+
+	ok, err := paths.Match(pattern[0], name[0])
+	if !ok || err != nil {
+		return false, err
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+const (
+	lexicalOrder     = "lexical"
+	naturalOrder     = "natural"
+	frontMatterOrder = "frontmatter"
+)
+
+// sortMatches orders the files matched by a glob include, either lexically,
+// lexically but with runs of digits compared by their numeric value, or by
+// the value of the order key in each file's front matter.
+func sortMatches(fsys fs.FS, matches []string, order string) error {
+	switch order {
+	case "", lexicalOrder:
+		sort.Strings(matches)
+	case naturalOrder:
+		sort.SliceStable(matches, func(i, j int) bool {
+			return naturalLess(matches[i], matches[j])
+		})
+	case frontMatterOrder:
+		keys := map[string]float64{}
+		for _, m := range matches {
+			fm, err := readFrontMatter(fsys, m)
+			if err != nil {
+				return fmt.Errorf("%s: %w", m, err)
+			}
+			if n, ok := fm.number("order"); ok {
+				keys[m] = n
+			}
+		}
+
+		sort.Strings(matches)
+		sort.SliceStable(matches, func(i, j int) bool {
+			a, aok := keys[matches[i]]
+			b, bok := keys[matches[j]]
+			if aok && bok {
+				return a < b
+			}
+			// files without an order come after all of those with one
+			return aok && !bok
+		})
+	default:
+		return fmt.Errorf("unknown include order %q, must be one of %s, %s or %s", order, lexicalOrder, naturalOrder, frontMatterOrder)
+	}
+	return nil
+}
+
+func readFrontMatter(fsys fs.FS, name string) (*frontMatter, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := [][]byte{}
+	readLineByLine(f, func(l []byte, _ int, err error) {
+		if err == nil {
+			lines = append(lines, l)
+		}
+	})
+	return parseFrontMatter(lines)
+}
+
+// naturalLess compares a and b lexically, other than for runs
+// of digits which are compared by their numeric value.
+func naturalLess(a, b string) bool {
+	for len(a) > 0 && len(b) > 0 {
+		ad, bd := leadingDigits(a), leadingDigits(b)
+		if len(ad) > 0 && len(bd) > 0 {
+			an, bn := strings.TrimLeft(ad, "0"), strings.TrimLeft(bd, "0")
+			if len(an) != len(bn) {
+				return len(an) < len(bn)
+			}
+			if an != bn {
+				return an < bn
+			}
+			a, b = a[len(ad):], b[len(bd):]
+			continue
+		}
+
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	if i < 0 {
+		return s
+	}
+	return s[:i]
+}
+
+// expandGlob returns an include for each file matched by the pattern of a glob
+// include, looked up in the same way as any other include path would be, in the
+// order given by its order attribute. The including document is never matched.
+func (d *Document) expandGlob(incl include, lookup []searchPath) ([]include, error) {
+	type candidate struct {
+		sp      searchPath
+		pattern string
+	}
+
+	candidates := []candidate{}
+	if d.src.fsys != nil && !strings.HasPrefix(incl.path, "/") {
+		candidates = append(candidates, candidate{d.src, paths.Join(paths.Dir(d.fsPath), incl.path)})
+	}
+	for _, sp := range lookup {
+		candidates = append(candidates, candidate{sp, paths.Clean(strings.TrimLeft(incl.path, "/"))})
+	}
+
+	for _, c := range candidates {
+		if !fs.ValidPath(c.pattern) {
+			continue
+		}
+
+		matches, err := glob(c.sp.fsys, c.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", incl.path, err)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		if err := sortMatches(c.sp.fsys, matches, incl.attrs["order"]); err != nil {
+			return nil, err
+		}
+
+		expanded := []include{}
+		for _, m := range matches {
+			if c.sp.key(m) == d.key {
+				continue
+			}
+
+			e := incl
+			e.glob, e.path, e.name = incl.path, m, paths.Base(m)
+			e.src, e.srcPath = c.sp, m
+			expanded = append(expanded, e)
+		}
+		if len(expanded) == 0 {
+			continue
+		}
+
+		log.Printfln("[%s] include pattern %s matched %d files in %s", d.name, incl.path, len(expanded), c.sp.name)
+		return expanded, nil
+	}
+
+	// left to be skipped over like any other missing optional include
+	if incl.optional {
+		return []include{incl}, nil
+	}
+	return nil, fmt.Errorf("%s: no files match include pattern %q", IncludeLink{d.displayKey(), d.srcLine(incl.linePos)}, incl.path)
+}
@@ -0,0 +1,124 @@
+package md
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var globfs = fstest.MapFS{
+	"index.md":            &fstest.MapFile{Data: []byte("# Handbook\n#include \"chapters/*.md\"\nend")},
+	"chapters/b.md":       &fstest.MapFile{Data: []byte("b")},
+	"chapters/a.md":       &fstest.MapFile{Data: []byte("a\n#include \"../shared.md\"")},
+	"chapters/notes.txt":  &fstest.MapFile{Data: []byte("not included")},
+	"shared.md":           &fstest.MapFile{Data: []byte("shared")},
+	"deep.md":             &fstest.MapFile{Data: []byte("#include \"guide/**/*.md\"")},
+	"guide/intro.md":      &fstest.MapFile{Data: []byte("intro")},
+	"guide/setup/unix.md": &fstest.MapFile{Data: []byte("unix")},
+	"guide/setup/win.md":  &fstest.MapFile{Data: []byte("win")},
+	"natural.md":          &fstest.MapFile{Data: []byte("#include \"steps/*.md\" order=natural")},
+	"steps/step10.md":     &fstest.MapFile{Data: []byte("ten")},
+	"steps/step2.md":      &fstest.MapFile{Data: []byte("two")},
+	"steps/step1.md":      &fstest.MapFile{Data: []byte("one")},
+	"ordered.md":          &fstest.MapFile{Data: []byte("#include \"posts/*.md\" order=frontmatter")},
+	"posts/a.md":          &fstest.MapFile{Data: []byte("---\norder: 2\n---\na")},
+	"posts/b.md":          &fstest.MapFile{Data: []byte("+++\norder = 1\n+++\nb")},
+	"posts/c.md":          &fstest.MapFile{Data: []byte("c")},
+	"self.md":             &fstest.MapFile{Data: []byte("self\n#include \"*.md\"")},
+	"missing.md":          &fstest.MapFile{Data: []byte("#include \"nothing/*.md\"")},
+}
+
+func TestGlobIncludeIsExpandedLexically(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "index.md", globfs), "# Handbook\na\nshared\nb\nend\n")
+}
+
+func TestGlobIncludeWithMarkers(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "index.md", globfs, WithMarkers()),
+		"# Handbook\n#include \"chapters/*.md\"\n<!-- mdx:begin \"chapters/*.md\" -->\na\nshared\nb\n<!-- mdx:end \"chapters/*.md\" -->\nend\n",
+	)
+}
+
+func TestGlobIncludeMatchesAnyDepth(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "deep.md", globfs), "intro\nunix\nwin\n")
+}
+
+func TestGlobIncludeNaturalOrder(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "natural.md", globfs), "one\ntwo\nten\n")
+}
+
+func TestGlobIncludeFrontMatterOrder(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "ordered.md", globfs), "b\na\nc\n")
+}
+
+func TestGlobIncludeNeverMatchesIncludingDocument(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("self.md", fstest.MapFS{
+		"self.md":  globfs["self.md"],
+		"other.md": &fstest.MapFile{Data: []byte("other")},
+	})
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.ResolveIncludes("."))
+	is.Equal(string(mergeLines(doc.lineContent)), "self\nother")
+}
+
+func TestGlobIncludeWithoutMatches(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("missing.md", globfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", globfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), `missing.md:1: no files match include pattern "nothing/*.md"`))
+}
+
+func TestNaturalLess(t *testing.T) {
+	is := is.New(t)
+
+	is.True(naturalLess("step2", "step10"))
+	is.True(!naturalLess("step10", "step2"))
+	is.True(naturalLess("a1b", "a01c"))
+	is.True(naturalLess("a", "ab"))
+	is.True(!naturalLess("b", "a1"))
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	is := is.New(t)
+
+	fm, err := parseFrontMatter(splitLines([]byte("---\norder: 3\ntitle: x\n---\ncontent")))
+	is.NoErr(err)
+	is.Equal(fm.size, 4)
+	n, ok := fm.number("order")
+	is.True(ok)
+	is.Equal(n, 3.0)
+
+	fm, err = parseFrontMatter(splitLines([]byte("+++\norder = 1.5\n+++")))
+	is.NoErr(err)
+	n, ok = fm.number("order")
+	is.True(ok)
+	is.Equal(n, 1.5)
+
+	fm, err = parseFrontMatter(splitLines([]byte("# no front matter\n---")))
+	is.NoErr(err)
+	is.True(fm == nil)
+
+	_, err = parseFrontMatter(splitLines([]byte("---\n: bad: [\n---")))
+	is.True(err != nil)
+}
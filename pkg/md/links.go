@@ -0,0 +1,157 @@
+package md
+
+import (
+	paths "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	linkTargetRegex = regexp.MustCompile(`!?\[[^\]]*\]\([ \t]*(<[^>]*>|[^)\s]+)`)
+	linkDefRegex    = regexp.MustCompile(`^(?:[ \t]*>)*[ \t]*\[[^\]]+\]:[ \t]*(<[^>]*>|\S+)`)
+	htmlLinkRegex   = regexp.MustCompile(`(?i)\b(?:src|href)[ \t]*=[ \t]*(?:"([^"]*)"|'([^']*)')`)
+	schemeRegex     = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+)
+
+// WithOutputPath sets the path the resolved document is to be written to,
+// which relative links within included documents are rewritten relative
+// to. By default that's the location of the document being resolved.
+func WithOutputPath(path string) Option {
+	return func(o *options) {
+		o.outputPath = path
+	}
+}
+
+// location is the directory a document sits within, along with the name
+// of the file system that is for documents which aren't on disk, whose
+// directory is given as an absolute path. Remote documents are located
+// by their URL.
+type location struct {
+	fsys string
+	dir  string
+}
+
+func (d *Document) location() location {
+	switch {
+	case isRemote(d.fsPath):
+		return location{fsys: "remote", dir: d.fsPath}
+	case len(d.src.dir) > 0:
+		return location{dir: filepath.Join(d.src.dir, filepath.FromSlash(paths.Dir(d.fsPath)))}
+	}
+	return location{fsys: d.src.name, dir: paths.Dir(d.fsPath)}
+}
+
+// outputLocation returns where the resolved document is written to.
+func (d *Document) outputLocation() location {
+	if len(d.opts.outputPath) == 0 {
+		return d.location()
+	}
+	if abs, err := filepath.Abs(d.opts.outputPath); err == nil {
+		return location{dir: filepath.Dir(abs)}
+	}
+	return location{dir: filepath.Dir(d.opts.outputPath)}
+}
+
+func isRelativeLink(t string) bool {
+	return len(t) > 0 && !strings.HasPrefix(t, "#") && !strings.HasPrefix(t, "/") &&
+		!strings.HasPrefix(t, `\`) && !schemeRegex.MatchString(t)
+}
+
+// relink returns the link target t, relative to the from location, made
+// relative to the to location instead. Targets within remote documents
+// are made absolute, and those which can't be related are left as is.
+func relink(t string, from, to location) string {
+	bracketed := strings.HasPrefix(t, "<") && strings.HasSuffix(t, ">")
+	if bracketed {
+		t = t[1 : len(t)-1]
+	}
+	if !isRelativeLink(t) || from == to {
+		return wrap(t, bracketed)
+	}
+
+	if from.fsys == "remote" {
+		if u, err := resolveURL(from.dir, t); err == nil {
+			return wrap(u, bracketed)
+		}
+		return wrap(t, bracketed)
+	}
+	if from.fsys != to.fsys {
+		return wrap(t, bracketed)
+	}
+
+	p, suffix := t, ""
+	if i := strings.IndexAny(t, "?#"); i >= 0 {
+		p, suffix = t[:i], t[i:]
+	}
+	if strings.HasSuffix(p, "/") {
+		suffix = "/" + suffix
+	}
+
+	target := filepath.Join(filepath.FromSlash(from.dir), filepath.FromSlash(p))
+	rel, err := filepath.Rel(filepath.FromSlash(to.dir), target)
+	if err != nil {
+		return wrap(t, bracketed)
+	}
+	return wrap(filepath.ToSlash(rel)+suffix, bracketed)
+}
+
+func wrap(t string, bracketed bool) string {
+	if bracketed {
+		return "<" + t + ">"
+	}
+	return t
+}
+
+// rewriteLinks rewrites the targets of the relative links and images within
+// the document, including reference link definitions and the src and href
+// attributes of HTML, so they remain correct from the given location.
+func (d *Document) rewriteLinks(to location) {
+	from := d.location()
+	if from == to {
+		return
+	}
+
+	code := codeLines(d.lineContent)
+	for i, l := range d.lineContent {
+		if code[i] {
+			continue
+		}
+
+		s := string(l)
+		spans := inlineCodeSpans(s)
+		for _, re := range []*regexp.Regexp{linkTargetRegex, linkDefRegex, htmlLinkRegex} {
+			s = replaceTargets(s, re, spans, func(t string) string { return relink(t, from, to) })
+			spans = inlineCodeSpans(s)
+		}
+		d.lineContent[i] = []byte(s)
+	}
+}
+
+// replaceTargets replaces the first group matched by each match of re
+// within l, other than for those within any of the given code spans.
+func replaceTargets(l string, re *regexp.Regexp, spans [][2]int, replace func(string) string) string {
+	matches := re.FindAllStringSubmatchIndex(l, -1)
+	if len(matches) == 0 {
+		return l
+	}
+
+	b := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		if withinSpans(m[0], spans) {
+			continue
+		}
+		for g := 2; g+1 < len(m); g += 2 {
+			if m[g] < 0 {
+				continue
+			}
+			b.WriteString(l[last:m[g]])
+			b.WriteString(replace(l[m[g]:m[g+1]]))
+			last = m[g+1]
+			break
+		}
+	}
+	b.WriteString(l[last:])
+	return b.String()
+}
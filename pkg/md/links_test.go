@@ -0,0 +1,86 @@
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var linksfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte("# Project\n[Guide](docs/guide.md)\n#include \"docs/api/usage.md\"")},
+	"docs/api/usage.md": &fstest.MapFile{Data: []byte(
+		"![Diagram](./img/diagram.png \"The diagram\")\n" +
+			"See [the guide](../guide.md#install), [below](#usage), [site](https://example.com/x.md) and [root](/abs.md).\n" +
+			"[ref]: ./ref.md?raw=1\n" +
+			"> [quoted]: <spaced name.md>\n" +
+			"<img src=\"img/logo.svg\" alt='logo'> <a href='dir/'>dir</a>\n" +
+			"`[code](img/code.png)` [after](img/after.png)\n" +
+			"```md\n[fenced](img/fenced.png)\n```\n" +
+			"#include \"nested/part.md\"",
+	)},
+	"docs/api/nested/part.md": &fstest.MapFile{Data: []byte("![nested](../../shared.png)")},
+}
+
+func TestRelativeLinksAreRewritten(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", linksfs),
+		"# Project\n[Guide](docs/guide.md)\n"+
+			"![Diagram](docs/api/img/diagram.png \"The diagram\")\n"+
+			"See [the guide](docs/guide.md#install), [below](#usage), [site](https://example.com/x.md) and [root](/abs.md).\n"+
+			"[ref]: docs/api/ref.md?raw=1\n"+
+			"> [quoted]: <docs/api/spaced name.md>\n"+
+			"<img src=\"docs/api/img/logo.svg\" alt='logo'> <a href='docs/api/dir/'>dir</a>\n"+
+			"`[code](img/code.png)` [after](docs/api/img/after.png)\n"+
+			"```md\n[fenced](img/fenced.png)\n```\n"+
+			"![nested](docs/shared.png)\n",
+	)
+}
+
+func TestRelativeLinksAreRewrittenForOutputPath(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(dir, "docs", "img"), os.ModePerm))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "README.md"), []byte("[guide](docs/guide.md)\n#include \"docs/part.md\""), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "docs", "part.md"), []byte("![img](img/a.png)"), 0644))
+
+	doc, err := Open(filepath.Join(dir, "README.md"))
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.Configure(WithOutputPath(filepath.Join(dir, "dist", "README.md"))))
+	is.NoErr(doc.ResolveIncludes(dir))
+	is.Equal(string(mergeLines(doc.lineContent)), "[guide](../docs/guide.md)\n![img](../docs/img/a.png)")
+}
+
+func TestLinksAreRewrittenOnceVariablesAreExpanded(t *testing.T) {
+	is := is.New(t)
+
+	varlinksfs := fstest.MapFS{
+		"README.md":   &fstest.MapFile{Data: []byte("#include \"docs/b.md\" page=\"c.md\"")},
+		"docs/b.md":   &fstest.MapFile{Data: []byte("---\nparams:\n  page:\n---\n[y](${BASE}/z) [x](${DIR}/x.md) [p]({{ page }})")},
+		"docs/c.md":   &fstest.MapFile{Data: []byte("c")},
+		"docs/d/x.md": &fstest.MapFile{Data: []byte("x")},
+	}
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", varlinksfs, WithDefines(map[string]string{"BASE": "https://cdn.example.com", "DIR": "d"})),
+		"[y](https://cdn.example.com/z) [x](docs/d/x.md) [p](docs/c.md)\n",
+	)
+}
+
+func TestRelink(t *testing.T) {
+	is := is.New(t)
+
+	from, to := location{fsys: "fs[0]", dir: "a/b"}, location{fsys: "fs[0]", dir: "."}
+	is.Equal(relink("c.md", from, to), "a/b/c.md")
+	is.Equal(relink("../c.md#x", from, to), "a/c.md#x")
+	is.Equal(relink("mailto:a@b.com", from, to), "mailto:a@b.com")
+	is.Equal(relink("c.md", from, location{fsys: "fs[1]", dir: "."}), "c.md")
+	is.Equal(relink("c.md", location{fsys: "remote", dir: "https://example.com/docs/a.md"}, to), "https://example.com/docs/c.md")
+}
@@ -0,0 +1,446 @@
+package md
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const tabWidth = 4
+
+// indentWidth returns the width of the leading whitespace of l,
+// with tabs advancing to the next tab stop.
+func indentWidth(l []byte) int {
+	w := 0
+	for _, c := range l {
+		switch c {
+		case ' ':
+			w++
+		case '\t':
+			w += tabWidth - w%tabWidth
+		default:
+			return w
+		}
+	}
+	return w
+}
+
+func isBlank(l []byte) bool {
+	return len(bytes.TrimSpace(l)) == 0
+}
+
+// commonIndent returns the smallest indentation across all non blank lines.
+func commonIndent(lines [][]byte) int {
+	common := -1
+	for _, l := range lines {
+		if isBlank(l) {
+			continue
+		}
+		if w := indentWidth(l); common < 0 || w < common {
+			common = w
+		}
+	}
+	if common < 0 {
+		return 0
+	}
+	return common
+}
+
+// stripContainers removes leading whitespace and block quote markers from l.
+func stripContainers(l []byte) []byte {
+	return bytes.TrimLeft(l, " \t>")
+}
+
+// containerPrefix returns the leading whitespace and block quote markers of l.
+func containerPrefix(l []byte) []byte {
+	return l[:len(l)-len(stripContainers(l))]
+}
+
+// listItemPrefixes returns the prefixes for lines taking the place of the
+// content of l, with the first keeping the list marker of a list item opened
+// by l and the rest indented to the column of the item's content. For lines
+// which don't open list items both are the container prefix of l.
+func listItemPrefixes(l []byte) ([]byte, []byte) {
+	prefix := containerPrefix(l)
+	m := listItemRegex.Find(stripContainers(l))
+	if m == nil {
+		return prefix, prefix
+	}
+
+	first := append(append([]byte{}, prefix...), m...)
+	rest := append(append([]byte{}, prefix...), bytes.Repeat([]byte(" "), contentIndent(stripContainers(l)))...)
+	return first, rest
+}
+
+// prefixLines returns a copy of lines with prefix added to the start of each,
+// without any trailing whitespace of the prefix for blank lines.
+func prefixLines(lines [][]byte, prefix []byte) [][]byte {
+	if len(prefix) == 0 {
+		return lines
+	}
+
+	blank := bytes.TrimRight(prefix, " \t")
+	prefixed := make([][]byte, len(lines))
+	for i, l := range lines {
+		p := prefix
+		if isBlank(l) {
+			p, l = blank, nil
+		}
+		prefixed[i] = append(append([]byte{}, p...), l...)
+	}
+	return prefixed
+}
+
+type fence struct {
+	char byte
+	size int
+}
+
+func openingFence(l []byte) (fence, bool) {
+	content := stripContainers(l)
+	if len(content) < 3 || (content[0] != '`' && content[0] != '~') {
+		return fence{}, false
+	}
+
+	f := fence{char: content[0]}
+	for f.size < len(content) && content[f.size] == f.char {
+		f.size++
+	}
+	if f.size < 3 {
+		return fence{}, false
+	}
+
+	// the info string of a backtick fence can't itself contain backticks
+	if f.char == '`' && bytes.IndexByte(content[f.size:], '`') >= 0 {
+		return fence{}, false
+	}
+	return f, true
+}
+
+func (f fence) closedBy(l []byte) bool {
+	content := bytes.TrimSpace(stripContainers(l))
+	if len(content) < f.size {
+		return false
+	}
+	for _, c := range content {
+		if c != f.char {
+			return false
+		}
+	}
+	return true
+}
+
+var listItemRegex = regexp.MustCompile(`^[ \t]*([-+*]|\d{1,9}[.)])[ \t]+`)
+
+// contentIndent returns the column content starts at within l, which for
+// list items is the column following the list marker.
+func contentIndent(l []byte) int {
+	m := listItemRegex.Find(l)
+	if m == nil || len(m) == len(l) {
+		return indentWidth(l)
+	}
+
+	w := 0
+	for _, c := range m {
+		if c == '\t' {
+			w += tabWidth - w%tabWidth
+			continue
+		}
+		w++
+	}
+	return w
+}
+
+// codeLines reports for each line whether it is part of a fenced or indented
+// code block, with the fences themselves included. Indentation is measured
+// relative to the indentation common to all lines, and to the content column
+// of any list item a line is nested within, so that content nested in list
+// items isn't mistaken for code.
+func codeLines(lines [][]byte) []bool {
+	code := make([]bool, len(lines))
+	common := commonIndent(lines)
+
+	var open *fence
+	indented, codeIndent, context := false, 0, 0
+	prevBlank := true
+	for i, l := range lines {
+		if open != nil {
+			code[i] = true
+			if open.closedBy(l) {
+				open = nil
+			}
+			continue
+		}
+
+		if isBlank(l) {
+			prevBlank = true
+			continue
+		}
+
+		rel := indentWidth(l) - common
+		switch {
+		case indented && rel >= codeIndent:
+			code[i] = true
+		case prevBlank && rel >= context+tabWidth:
+			indented, codeIndent = true, context+tabWidth
+			code[i] = true
+		default:
+			indented = false
+			if f, ok := openingFence(l); ok {
+				open = &f
+				code[i] = true
+				break
+			}
+			if listItemRegex.Match(l) || rel < context {
+				context = contentIndent(l) - common
+			}
+		}
+		prevBlank = false
+	}
+
+	return code
+}
+
+// inlineCodeSpans returns the start and end offsets of each inline code span within l.
+func inlineCodeSpans(l string) [][2]int {
+	spans := [][2]int{}
+	runAt := func(i int) int {
+		n := 0
+		for i+n < len(l) && l[i+n] == '`' {
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(l); i++ {
+		if l[i] == '\\' {
+			i++
+			continue
+		}
+		if l[i] != '`' {
+			continue
+		}
+
+		n := runAt(i)
+		closed := false
+		for j := i + n; j < len(l); j++ {
+			if l[j] != '`' {
+				continue
+			}
+			m := runAt(j)
+			if m == n {
+				spans = append(spans, [2]int{i, j + m})
+				i, closed = j+m-1, true
+				break
+			}
+			j += m - 1
+		}
+		if !closed {
+			i += n - 1
+		}
+	}
+
+	return spans
+}
+
+func withinSpans(pos int, spans [][2]int) bool {
+	for _, s := range spans {
+		if pos >= s[0] && pos < s[1] {
+			return true
+		}
+	}
+	return false
+}
+
+type heading struct {
+	// line is the index of the line the heading starts on, which for setext
+	// headings is the line of text before its underline
+	line   int
+	level  int
+	text   string
+	setext bool
+}
+
+var (
+	atxHeadingRegex    = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextUnderlineRgx = regexp.MustCompile(`^(=+|-+)[ \t]*$`)
+)
+
+// findHeadings returns the ATX and setext headings found within lines,
+// outside of any code blocks.
+func findHeadings(lines [][]byte) []heading {
+	headings := []heading{}
+	code := codeLines(lines)
+	common := commonIndent(lines)
+
+	isParagraph := func(i int) bool {
+		l := lines[i]
+		return !code[i] && !isBlank(l) && indentWidth(l)-common < tabWidth &&
+			!atxHeadingRegex.Match(bytes.TrimSpace(l)) && !listItemRegex.Match(l) &&
+			!bytes.HasPrefix(bytes.TrimSpace(l), []byte(">"))
+	}
+
+	// front matter is never part of the document's content
+	start := frontMatterSize(lines)
+	for i, l := range lines {
+		if i < start || code[i] || indentWidth(l)-common >= tabWidth {
+			continue
+		}
+
+		content := bytes.TrimSpace(l)
+		if m := atxHeadingRegex.FindSubmatch(content); m != nil {
+			headings = append(headings, heading{line: i, level: len(m[1]), text: string(m[2])})
+			continue
+		}
+
+		if m := setextUnderlineRgx.FindSubmatch(content); m != nil && i > 0 && isParagraph(i-1) {
+			level := 1
+			if m[1][0] == '-' {
+				level = 2
+			}
+			headings = append(headings, heading{
+				line: i - 1, level: level, text: string(bytes.TrimSpace(lines[i-1])), setext: true,
+			})
+		}
+	}
+
+	return headings
+}
+
+var (
+	inlineLinkRegex  = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	refLinkRegex     = regexp.MustCompile(`!?\[([^\]]*)\]\[[^\]]*\]`)
+	htmlTagRegex     = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	htmlCommentRegex = regexp.MustCompile(`<!--.*?-->`)
+)
+
+// plainText strips the inline markup from s which doesn't
+// make it through to the text of the rendered heading.
+func plainText(s string) string {
+	s = inlineLinkRegex.ReplaceAllString(s, "$1")
+	s = refLinkRegex.ReplaceAllString(s, "$1")
+	s = htmlTagRegex.ReplaceAllString(s, "")
+	s = htmlCommentRegex.ReplaceAllString(s, "")
+	return strings.NewReplacer("`", "", "*", "").Replace(s)
+}
+
+// headingTextStart returns the index the text of the given heading line
+// starts at, after any indentation and the opening of ATX headings.
+func headingTextStart(l string) int {
+	start := len(l) - len(strings.TrimLeft(l, " \t"))
+	if strings.HasPrefix(l[start:], "#") {
+		start += len(l[start:]) - len(strings.TrimLeft(l[start:], "#"))
+		start += len(l[start:]) - len(strings.TrimLeft(l[start:], " \t"))
+	}
+	return start
+}
+
+// anchor returns the anchor GitHub generates for a heading with the given text.
+func anchor(text string) string {
+	b := strings.Builder{}
+	for _, r := range strings.ToLower(strings.TrimSpace(plainText(text))) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// anchors returns the anchor of each heading, with those which would otherwise
+// collide suffixed with a counter in the same way that GitHub does.
+func anchors(headings []heading) []string {
+	used, counts := map[string]bool{}, map[string]int{}
+	result := make([]string, len(headings))
+	for i, h := range headings {
+		base := anchor(h.text)
+		unique := base
+		for used[unique] {
+			counts[base]++
+			unique = fmt.Sprintf("%s-%d", base, counts[base])
+		}
+		used[unique] = true
+		result[i] = unique
+	}
+	return result
+}
+
+// shiftHeadings returns lines with the level of each heading within them
+// changed by the given amount, clamped between levels 1 and 6. Setext
+// headings shifted beyond level 2 are rewritten as ATX headings.
+func shiftHeadings(lines [][]byte, by int) [][]byte {
+	headings := findHeadings(lines)
+	if by == 0 || len(headings) == 0 {
+		return lines
+	}
+
+	shifted := make([][]byte, len(lines))
+	copy(shifted, lines)
+	removed := map[int]bool{}
+	for _, h := range headings {
+		level := h.level + by
+		if level < 1 {
+			level = 1
+		}
+		if level > 6 {
+			level = 6
+		}
+
+		l := lines[h.line]
+		indent := l[:len(l)-len(bytes.TrimLeft(l, " \t"))]
+		switch {
+		case !h.setext:
+			content := bytes.TrimLeft(l, " \t")
+			rest := bytes.TrimLeft(content, "#")
+			shifted[h.line] = join(indent, bytes.Repeat([]byte("#"), level), rest)
+		case level <= 2:
+			underline := lines[h.line+1]
+			char := []byte("=")
+			if level == 2 {
+				char = []byte("-")
+			}
+			size := len(bytes.TrimSpace(underline))
+			shifted[h.line+1] = join(underline[:len(underline)-len(bytes.TrimLeft(underline, " \t"))], bytes.Repeat(char, size))
+		default:
+			shifted[h.line] = join(indent, bytes.Repeat([]byte("#"), level), []byte(" "), bytes.TrimSpace(l))
+			removed[h.line+1] = true
+		}
+	}
+
+	if len(removed) == 0 {
+		return shifted
+	}
+
+	result := make([][]byte, 0, len(shifted)-len(removed))
+	for i, l := range shifted {
+		if !removed[i] {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// autoShift returns the amount to shift the headings of lines by to sit
+// them directly below a heading of the given level.
+func autoShift(lines [][]byte, below int) int {
+	headings := findHeadings(lines)
+	if len(headings) == 0 {
+		return 0
+	}
+
+	top := headings[0].level
+	for _, h := range headings[1:] {
+		if h.level < top {
+			top = h.level
+		}
+	}
+	return below + 1 - top
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
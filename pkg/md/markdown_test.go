@@ -0,0 +1,173 @@
+package md
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+type codeLinesTest struct {
+	title    string
+	lines    string
+	expected []bool
+}
+
+var codeLinesTests = []codeLinesTest{
+	{
+		title:    "Backtick fence and its contents are code",
+		lines:    "text\n```md\n#include \"a.md\"\n```\ntext",
+		expected: []bool{false, true, true, true, false},
+	},
+	{
+		title:    "Tilde fence is only closed by a tilde fence at least as long",
+		lines:    "~~~~\n```\n~~~\n~~~~\ntext",
+		expected: []bool{true, true, true, true, false},
+	},
+	{
+		title:    "Indented code block following a blank line",
+		lines:    "text\n\n    #include \"a.md\"\n\n    more code\ntext",
+		expected: []bool{false, false, true, false, true, false},
+	},
+	{
+		title:    "Indented line can't interrupt a paragraph",
+		lines:    "text\n    #include \"a.md\"",
+		expected: []bool{false, false},
+	},
+	{
+		title:    "Content nested under a list item is not code",
+		lines:    "- item\n\n    #include \"a.md\"\n\n      code in item",
+		expected: []bool{false, false, false, false, true},
+	},
+	{
+		title:    "Indentation common to every line is ignored",
+		lines:    "\t\t# Heading\n\n\t\t#include \"a.md\"\n\n\t\t\tcode",
+		expected: []bool{false, false, false, false, true},
+	},
+	{
+		title:    "Fence within a block quote",
+		lines:    "> ```\n> #include \"a.md\"\n> ```\n> #include \"b.md\"",
+		expected: []bool{true, true, true, false},
+	},
+}
+
+func TestCodeLines(t *testing.T) {
+	for _, tt := range codeLinesTests {
+		t.Run(tt.title, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(codeLines(splitLines([]byte(tt.lines))), tt.expected)
+		})
+	}
+}
+
+func TestInlineCodeSpans(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(inlineCodeSpans("no code here"), [][2]int{})
+	is.Equal(inlineCodeSpans("use `#include \"x\"` to include"), [][2]int{{4, 18}})
+	is.Equal(inlineCodeSpans("``a ` b`` and `c`"), [][2]int{{0, 9}, {14, 17}})
+	is.Equal(inlineCodeSpans("\\`not code` and ``unclosed"), [][2]int{})
+}
+
+func TestIncludesWithinCodeAreIgnored(t *testing.T) {
+	is := is.New(t)
+
+	incl, ok := isInclude("see `#include \"a.md\"` for details")
+	is.True(!ok)
+	is.Equal(incl.path, "")
+
+	incl, ok = isInclude("`code` #include \"a.md\"")
+	is.True(ok)
+	is.Equal(incl.path, "a.md")
+
+	doc := Document{
+		name: "README.md",
+		r:    io.NopCloser(strings.NewReader("# Syntax\n```\n#include \"a.md\"\n```\n\n    #include \"b.md\"\n\n#include \"c.md\"")),
+	}
+	is.NoErr(doc.parse())
+	is.Equal(len(doc.includes), 1)
+	is.Equal(doc.includes[0].path, "c.md")
+}
+
+func TestFindHeadings(t *testing.T) {
+	is := is.New(t)
+
+	headings := findHeadings(splitLines([]byte(
+		"# Title #\n\nSetext heading\n---\n\n```\n# not a heading\n```\n#hashtag\n###### Six\nSetext one\n===",
+	)))
+	is.Equal(headings, []heading{
+		{line: 0, level: 1, text: "Title"},
+		{line: 2, level: 2, text: "Setext heading", setext: true},
+		{line: 9, level: 6, text: "Six"},
+		{line: 10, level: 1, text: "Setext one", setext: true},
+	})
+}
+
+func TestAnchors(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(anchor("Installation"), "installation")
+	is.Equal(anchor("Getting Started: `go install`!"), "getting-started-go-install")
+	is.Equal(anchor("See [the docs](https://example.com) & more"), "see-the-docs--more")
+	is.Equal(anchor("snake_case and dashes-too"), "snake_case-and-dashes-too")
+
+	is.Equal(anchors([]heading{
+		{text: "Example"}, {text: "Example"}, {text: "Example 1"}, {text: "Example"},
+	}), []string{"example", "example-1", "example-1-1", "example-2"})
+}
+
+type shiftHeadingsTest struct {
+	title    string
+	lines    string
+	by       int
+	expected string
+}
+
+var shiftHeadingsTests = []shiftHeadingsTest{
+	{
+		title:    "ATX headings are demoted",
+		lines:    "# Title\ntext\n## Sub ##\n  ### Indented",
+		by:       2,
+		expected: "### Title\ntext\n#### Sub ##\n  ##### Indented",
+	},
+	{
+		title:    "ATX headings are promoted and clamped at level 1",
+		lines:    "# Title\n### Sub",
+		by:       -2,
+		expected: "# Title\n# Sub",
+	},
+	{
+		title:    "Headings are clamped at level 6",
+		lines:    "##### Five\n###### Six",
+		by:       3,
+		expected: "###### Five\n###### Six",
+	},
+	{
+		title:    "Setext heading stays setext up to level 2",
+		lines:    "Title\n=====\ntext",
+		by:       1,
+		expected: "Title\n-----\ntext",
+	},
+	{
+		title:    "Setext heading beyond level 2 becomes ATX",
+		lines:    "Title\n=====\nSub\n---\ntext",
+		by:       2,
+		expected: "### Title\n#### Sub\ntext",
+	},
+	{
+		title:    "Headings within code are left alone",
+		lines:    "# Title\n```\n# comment\n```",
+		by:       1,
+		expected: "## Title\n```\n# comment\n```",
+	},
+}
+
+func TestShiftHeadings(t *testing.T) {
+	for _, tt := range shiftHeadingsTests {
+		t.Run(tt.title, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(string(mergeLines(shiftHeadings(splitLines([]byte(tt.lines)), tt.by))), tt.expected)
+		})
+	}
+}
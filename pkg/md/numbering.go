@@ -0,0 +1,166 @@
+package md
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numberMarker follows the section numbers added alongside markers,
+// so that they're replaced when the document is resolved again.
+const numberMarker = "<!-- mdx:number -->"
+
+// unnumberedMarker takes the place of the {-} or {.unnumbered} of headings
+// alongside markers, so that it's hidden from their text and anchors.
+const unnumberedMarker = "<!-- mdx:unnumbered -->"
+
+var (
+	sectionNumberRegex = regexp.MustCompile(`^\d+(?:\.\d+)*` + regexp.QuoteMeta(numberMarker) + `[ \t]*`)
+	unnumberedRegex    = regexp.MustCompile(`[ \t]*(?:\{(?:-|\.unnumbered)\}|` + regexp.QuoteMeta(unnumberedMarker) + `)([ \t]+#+)?[ \t]*$`)
+)
+
+// WithSectionNumbers prefixes the text of each heading with levels between
+// min and max with its hierarchical section number, such as 1.2.3, counted
+// across the resolved document. Headings whose text ends with {-} or
+// {.unnumbered} are left unnumbered along with every heading within their
+// sections, with that marker removed, or hidden within a marker of its own
+// alongside markers. Alongside markers, numbers are also
+// followed by a marker of their own, for them to be replaced when the document
+// is resolved again. Links to the headings from within the same document are
+// updated to the anchors the headings have once numbered.
+func WithSectionNumbers(min, max int) Option {
+	return func(o *options) {
+		o.numbering = true
+		o.numberLevels = [2]int{min, max}
+	}
+}
+
+// numberSections prefixes the headings within the resolved document with
+// their section numbers, if section numbering has been enabled, replacing
+// the numbers added to them when they were last resolved alongside markers,
+// and updating the links to the anchors of the headings this changes.
+func (d *Document) numberSections() error {
+	headings := findHeadings(d.lineContent)
+	origins := d.lineOrigins(len(d.lineContent))
+
+	// links may be to the anchors headings had as written, or without their numbers
+	_, written := localAnchors(headings, origins)
+	for _, h := range headings {
+		d.lineContent[h.line] = removeSectionNumber(d.lineContent[h.line])
+	}
+	_, unnumbered := localAnchors(rereadHeadings(d.lineContent, headings), origins)
+
+	if err := d.applySectionNumbers(headings); err != nil {
+		return err
+	}
+
+	_, numbered := localAnchors(rereadHeadings(d.lineContent, headings), origins)
+	moved := map[*Document]map[string]string{}
+	for i, h := range headings {
+		o := origins[h.line]
+		for _, from := range []string{written[i], unnumbered[i]} {
+			if len(from) == 0 || from == numbered[i] {
+				continue
+			}
+			if moved[o] == nil {
+				moved[o] = map[string]string{}
+			}
+			moved[o][from] = numbered[i]
+		}
+	}
+	d.relinkAnchors(origins, moved)
+	return nil
+}
+
+func (d *Document) applySectionNumbers(headings []heading) error {
+	if !d.opts.numbering {
+		return nil
+	}
+
+	min, max := d.opts.numberLevels[0], d.opts.numberLevels[1]
+	if min < 1 || max > 6 || min > max {
+		return fmt.Errorf("invalid section numbering levels %d-%d, must be heading levels from 1 to 6", min, max)
+	}
+
+	// unnumbered is the level of the unnumbered section headings are within, if any
+	counters, unnumbered := make([]int, max-min+1), 0
+	for _, h := range headings {
+		if unnumbered > 0 && h.level > unnumbered {
+			continue
+		}
+		unnumbered = 0
+
+		l := string(d.lineContent[h.line])
+		if unnumberedRegex.MatchString(l) {
+			unnumbered = h.level
+			replacement := "$1"
+			if d.opts.markers {
+				replacement = " " + unnumberedMarker + "$1"
+			}
+			d.lineContent[h.line] = []byte(unnumberedRegex.ReplaceAllString(l, replacement))
+			continue
+		}
+
+		if h.level < min || h.level > max {
+			continue
+		}
+
+		depth := h.level - min
+		counters[depth]++
+		for i := depth + 1; i < len(counters); i++ {
+			counters[i] = 0
+		}
+
+		number := make([]string, depth+1)
+		for i := range number {
+			number[i] = strconv.Itoa(counters[i])
+		}
+		if d.opts.markers {
+			number[depth] += numberMarker
+		}
+		d.lineContent[h.line] = sectionNumber(l, strings.Join(number, "."))
+	}
+	return nil
+}
+
+// numberStart returns the index the section number of the given heading line
+// is placed at, which is the start of its text after any explicit ID.
+func numberStart(l string) int {
+	start := headingTextStart(l)
+	return start + len(explicitIDRegex.FindString(l[start:]))
+}
+
+// sectionNumber returns the given heading line with the given section
+// number placed at the start of its text.
+func sectionNumber(l, number string) []byte {
+	start := numberStart(l)
+	return []byte(fmt.Sprintf("%s%s %s", l[:start], number, l[start:]))
+}
+
+// removeSectionNumber returns the given heading line without
+// the section number added to it alongside markers, if any.
+func removeSectionNumber(l []byte) []byte {
+	s := string(l)
+	start := numberStart(s)
+	return []byte(s[:start] + sectionNumberRegex.ReplaceAllString(s[start:], ""))
+}
+
+// rereadHeadings returns the given headings with their text read again from
+// lines, which have been changed without changing the headings within them.
+func rereadHeadings(lines [][]byte, headings []heading) []heading {
+	reread := make([]heading, len(headings))
+	for i, h := range headings {
+		reread[i] = h
+		content := bytes.TrimSpace(lines[h.line])
+		if h.setext {
+			reread[i].text = string(content)
+			continue
+		}
+		if m := atxHeadingRegex.FindSubmatch(content); m != nil {
+			reread[i].text = string(m[2])
+		}
+	}
+	return reread
+}
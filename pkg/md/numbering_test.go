@@ -0,0 +1,116 @@
+package md
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var numberingfs = fstest.MapFS{
+	"spec.md": &fstest.MapFile{Data: []byte(
+		"# Spec\n#toc min=2\n## Preface {-}\n#include \"scope.md\"\n#include \"terms.md\"\n## Appendix {.unnumbered} ##\n",
+	)},
+	"scope.md": &fstest.MapFile{Data: []byte("## Scope\n### Goals\n#### Detail\n### Non-goals\n")},
+	"terms.md": &fstest.MapFile{Data: []byte("Terms\n-----\n### Goals\n```\n## Not a heading\n```\n")},
+}
+
+func TestSectionNumbers(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "spec.md", numberingfs, WithSectionNumbers(2, 3)),
+		"# Spec\n- [Preface](#preface)\n- [1 Scope](#1-scope)\n  - [1.1 Goals](#11-goals)\n    - [Detail](#detail)\n  - [1.2 Non-goals](#12-non-goals)\n"+
+			"- [2 Terms](#2-terms)\n  - [2.1 Goals](#21-goals)\n- [Appendix](#appendix)\n"+
+			"## Preface\n## 1 Scope\n### 1.1 Goals\n#### Detail\n### 1.2 Non-goals\n"+
+			"2 Terms\n-----\n### 2.1 Goals\n```\n## Not a heading\n```\n## Appendix ##\n",
+	)
+}
+
+func TestUnnumberedSectionsAreUnnumberedThroughout(t *testing.T) {
+	is := is.New(t)
+
+	appendixfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(
+		"## Intro\n## Appendix {-}\n### Details\n#### More\n## Usage\n### Options\n# Reference {.unnumbered}\n## Commands",
+	)}}
+	is.Equal(
+		resolveAndWrite(is, "README.md", appendixfs, WithSectionNumbers(2, 6)),
+		"## 1 Intro\n## Appendix\n### Details\n#### More\n## 2 Usage\n### 2.1 Options\n# Reference\n## Commands\n",
+	)
+}
+
+func TestSectionNumbersWithMarkersAreRenumberedWhenResolvedAgain(t *testing.T) {
+	is := is.New(t)
+
+	once := resolveAndWrite(is, "spec.md", numberingfs, WithSectionNumbers(1, 6), WithMarkers())
+	is.True(strings.Contains(once, "# 1<!-- mdx:number --> Spec\n"))
+	is.True(strings.Contains(once, "## Preface <!-- mdx:unnumbered -->\n"))
+	is.True(strings.Contains(once, "- [Preface](#preface)\n"))
+	is.True(strings.Contains(once, "### 1.2.1<!-- mdx:number --> Goals\n"))
+
+	rerunfs := fstest.MapFS{"spec.md": &fstest.MapFile{Data: []byte(once)}}
+	for name, f := range numberingfs {
+		if name != "spec.md" {
+			rerunfs[name] = f
+		}
+	}
+	is.Equal(resolveAndWrite(is, "spec.md", rerunfs, WithSectionNumbers(1, 6), WithMarkers()), once)
+}
+
+func TestLinksToNumberedHeadingsAreUpdated(t *testing.T) {
+	is := is.New(t)
+
+	linksfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("# Guide\nSee [install](#install) and [usage](usage.md#usage).\n#include \"usage.md\"\n## Notes {-}\n[notes](#notes--)")},
+		"usage.md":  &fstest.MapFile{Data: []byte("## Install\n## Usage\nBack to [install](#install), not `[x](#usage)`.")},
+	}
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", linksfs, WithSectionNumbers(2, 6)),
+		"# Guide\nSee [install](#install) and [usage](usage.md#usage).\n## 1 Install\n## 2 Usage\nBack to [install](#1-install), not `[x](#usage)`.\n## Notes\n[notes](#notes)\n",
+	)
+
+	rootfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte("# Guide\n## Install\n[install](#install)")}}
+	once := resolveAndWrite(is, "README.md", rootfs, WithSectionNumbers(2, 6), WithMarkers())
+	is.Equal(once, "# Guide\n## 1<!-- mdx:number --> Install\n[install](#1-install)\n")
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithSectionNumbers(1, 6), WithMarkers()), "# 1<!-- mdx:number --> Guide\n## 1.1<!-- mdx:number --> Install\n[install](#11-install)\n")
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs), "# Guide\n## Install\n[install](#install)\n")
+}
+
+func TestSectionNumbersAfterExplicitIDs(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(string(sectionNumber(`## <a id="goals-1"></a>Goals`, "2.1")), `## <a id="goals-1"></a>2.1 Goals`)
+	is.Equal(string(sectionNumber("  Goals", "4")), "  4 Goals")
+	is.Equal(string(removeSectionNumber([]byte(`## <a id="goals-1"></a>1.3<!-- mdx:number --> Goals`))), `## <a id="goals-1"></a>Goals`)
+}
+
+func TestExistingNumbersAreKept(t *testing.T) {
+	is := is.New(t)
+
+	roadmapfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte("# 2024 Roadmap\n## 3 ways to go")}}
+	is.Equal(resolveAndWrite(is, "README.md", roadmapfs, WithSectionNumbers(1, 6)), "# 1 2024 Roadmap\n## 1.1 3 ways to go\n")
+
+	once := resolveAndWrite(is, "README.md", roadmapfs, WithSectionNumbers(2, 6), WithMarkers())
+	is.Equal(once, "# 2024 Roadmap\n## 1<!-- mdx:number --> 3 ways to go\n")
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithSectionNumbers(2, 6), WithMarkers()), once)
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs), "# 2024 Roadmap\n## 3 ways to go\n")
+}
+
+func TestInvalidSectionNumberLevels(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("spec.md", numberingfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.Configure(WithSectionNumbers(3, 2)))
+	err = doc.ResolveIncludes(".", numberingfs)
+	is.True(err != nil)
+	is.Equal(err.Error(), "invalid section numbering levels 3-2, must be heading levels from 1 to 6")
+}
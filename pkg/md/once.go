@@ -0,0 +1,52 @@
+package md
+
+import (
+	"regexp"
+	"strings"
+
+	log "github.com/tauraamui/imdclude/pkg/logging"
+)
+
+var pragmaOnceRegex = regexp.MustCompile(`^#?pragma[ \t]+once$`)
+
+// removePragmaOnce removes any pragma once markers from the document,
+// such as <!-- pragma once -->, and reports whether it had one.
+func (d *Document) removePragmaOnce() bool {
+	delims := d.opts.directiveDelims()
+	code := codeLines(d.lineContent)
+	found := false
+	d.filterLines(func(i int) bool {
+		if code[i] || !pragmaOnceRegex.MatchString(strings.TrimSpace(delims.unwrap(string(d.lineContent[i])))) {
+			return true
+		}
+		found = true
+		return false
+	})
+	return found
+}
+
+// onceKey identifies the content an include resolved to,
+// the same part of the same document resulting in the same key.
+func onceKey(incl include) string {
+	if len(incl.doc.key) == 0 {
+		return ""
+	}
+	return strings.Join([]string{incl.doc.key, incl.anchor, incl.attrs["lines"], incl.attrs["region"]}, "\x00")
+}
+
+// skipOnce reports whether the include is only to be emitted once and has
+// been already, otherwise recording that it is being emitted from now on.
+// Includes are checked in the order they're emitted within the tree.
+func (d *Document) skipOnce(incl include) bool {
+	key := onceKey(incl)
+	if len(key) == 0 {
+		return false
+	}
+
+	if d.opts.emitted[key] && (incl.once || incl.doc.once) {
+		log.Printfln("[%s] skipping include already emitted once: %s", d.name, incl.path)
+		return true
+	}
+	d.opts.emitted[key] = true
+	return false
+}
@@ -0,0 +1,47 @@
+package md
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var oncefs = fstest.MapFS{
+	"book.md":         &fstest.MapFile{Data: []byte("# Book\n#include \"chapters/one.md\"\n#include \"chapters/two.md\"\n#include \"glossary.md\"")},
+	"chapters/one.md": &fstest.MapFile{Data: []byte("## One\n#include \"../section.md\"")},
+	"chapters/two.md": &fstest.MapFile{Data: []byte("## Two\n#include \"../glossary.md\"")},
+	"section.md":      &fstest.MapFile{Data: []byte("### Section\n#include \"glossary.md\"")},
+	"glossary.md":     &fstest.MapFile{Data: []byte("<!-- pragma once -->\nglossary")},
+	"directive.md": &fstest.MapFile{Data: []byte(
+		"#include_once \"note.md\"\n#include \"note.md\"\n#include_once \"note.md\"\n#include_once \"sections.md#a\"\n#include_once \"sections.md#b\"\n#include_once \"sections.md#a\"",
+	)},
+	"note.md":       &fstest.MapFile{Data: []byte("note")},
+	"sections.md":   &fstest.MapFile{Data: []byte("# A\n# B")},
+	"code.md":       &fstest.MapFile{Data: []byte("#include \"codepragma.md\"\n#include \"codepragma.md\"")},
+	"codepragma.md": &fstest.MapFile{Data: []byte("```c\n#pragma once\n```")},
+}
+
+func TestPragmaOnceIsEmittedFirstTimeOnly(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "book.md", oncefs), "# Book\n## One\n### Section\nglossary\n## Two\n")
+}
+
+func TestIncludeOnce(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "directive.md", oncefs), "note\nnote\n# A\n# B\n")
+	is.Equal(
+		resolveAndWrite(is, "book.md", oncefs, WithMarkers()),
+		"# Book\n#include \"chapters/one.md\"\n<!-- mdx:begin \"chapters/one.md\" -->\n## One\n### Section\nglossary\n<!-- mdx:end \"chapters/one.md\" -->\n"+
+			"#include \"chapters/two.md\"\n<!-- mdx:begin \"chapters/two.md\" -->\n## Two\n<!-- mdx:end \"chapters/two.md\" -->\n"+
+			"#include \"glossary.md\"\n<!-- mdx:begin \"glossary.md\" -->\n<!-- mdx:end \"glossary.md\" -->\n",
+	)
+}
+
+func TestPragmaOnceWithinCode(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "code.md", oncefs), "```c\n#pragma once\n```\n```c\n#pragma once\n```\n")
+}
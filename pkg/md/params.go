@@ -0,0 +1,94 @@
+package md
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// directiveAttrs are the attributes which configure how an include
+// is resolved, rather than being passed on to it as parameters.
+var directiveAttrs = map[string]bool{
+	"lines":    true,
+	"region":   true,
+	"lang":     true,
+	"shift":    true,
+	"order":    true,
+	"optional": true,
+	"fallback": true,
+	"sha256":   true,
+}
+
+var paramRegex = regexp.MustCompile(`\{\{[ \t]*([A-Za-z_][\w-]*)[ \t]*\}\}`)
+
+// params returns the parameters declared by the front matter's params key,
+// along with their default values, which are nil for required parameters.
+func (fm *frontMatter) params() map[string]interface{} {
+	if fm == nil {
+		return nil
+	}
+	params, _ := fm.data["params"].(map[string]interface{})
+	return params
+}
+
+// applyParams replaces each {{name}} placeholder within the document with
+// the value of the parameter of that name, given as an attribute of the
+// directive including it or otherwise defaulted by the params declared in
+// the document's front matter. Documents are only treated as partials
+// like this if they are given parameters, or declare any of their own.
+func (d *Document) applyParams(incl include) error {
+	declared := d.frontMatter.params()
+	values := map[string]string{}
+	for k, v := range incl.attrs {
+		if !directiveAttrs[k] {
+			values[k] = v
+		}
+	}
+	if len(declared) == 0 && len(values) == 0 {
+		return nil
+	}
+
+	missing := map[string]bool{}
+	for k, v := range declared {
+		if _, ok := values[k]; ok {
+			continue
+		}
+		if v == nil {
+			missing[k] = true
+			continue
+		}
+		values[k] = fmt.Sprint(v)
+	}
+
+	// front matter declaring params only describes the partial itself
+	if len(declared) > 0 {
+		size := d.frontMatter.size
+		d.filterLines(func(i int) bool { return d.srcLine(i+1) > size })
+	}
+
+	for i, l := range d.lineContent {
+		if !paramRegex.Match(l) {
+			continue
+		}
+		d.lineContent[i] = paramRegex.ReplaceAllFunc(l, func(m []byte) []byte {
+			name := string(paramRegex.FindSubmatch(m)[1])
+			if v, ok := values[name]; ok {
+				return []byte(v)
+			}
+			missing[name] = true
+			return m
+		})
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(missing))
+	for n := range missing {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%s: missing parameters for %s: %s", IncludeLink{d.parent.displayKey(), d.includedAt}, d.displayKey(), strings.Join(names, ", "))
+}
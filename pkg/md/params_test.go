@@ -0,0 +1,58 @@
+package md
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var paramsfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte(
+		"# Setup\n#include \"card.md\" title=\"Install\" cmd=\"go install ./...\"\n#include \"card.md\" cmd=\"make\" lines=6-7",
+	)},
+	"card.md": &fstest.MapFile{Data: []byte(
+		"---\nparams:\n  title: Note\n  cmd:\n---\n> **{{ title }}**\n> `{{cmd}}`",
+	)},
+	"toml.md": &fstest.MapFile{Data: []byte("#include \"tomlcard.md\"")},
+	"tomlcard.md": &fstest.MapFile{Data: []byte(
+		"+++\n[params]\nlevel = 2\n+++\n{{level}}",
+	)},
+	"nested.md":     &fstest.MapFile{Data: []byte("#include \"wrapper.md\" name=\"inner\"")},
+	"wrapper.md":    &fstest.MapFile{Data: []byte("#include \"card.md\" title=\"{{name}}\" cmd=\"run {{name}}\"")},
+	"plain.md":      &fstest.MapFile{Data: []byte("#include \"template.md\"")},
+	"template.md":   &fstest.MapFile{Data: []byte("```go\n{{.Name}} {{ name }}\n```")},
+	"missing.md":    &fstest.MapFile{Data: []byte("# Title\n#include \"card.md\" title=\"x\"\n#include \"undeclared.md\" a=1")},
+	"undeclared.md": &fstest.MapFile{Data: []byte("{{a}} {{b}} {{c}}")},
+}
+
+func TestIncludeParams(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", paramsfs),
+		"# Setup\n> **Install**\n> `go install ./...`\n> **Note**\n> `make`\n",
+	)
+	is.Equal(resolveAndWrite(is, "toml.md", paramsfs), "2\n")
+	is.Equal(resolveAndWrite(is, "nested.md", paramsfs), "> **inner**\n> `run inner`\n")
+}
+
+func TestIncludesWithoutParamsAreNotPartials(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "plain.md", paramsfs), "```go\n{{.Name}} {{ name }}\n```\n")
+}
+
+func TestMissingIncludeParams(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("missing.md", paramsfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", paramsfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "missing.md:2: missing parameters for card.md: cmd"))
+	is.True(strings.Contains(err.Error(), "missing.md:3: missing parameters for undeclared.md: b, c"))
+}
@@ -0,0 +1,99 @@
+package md
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	log "github.com/tauraamui/imdclude/pkg/logging"
+)
+
+// IntegrityError is returned when the content of an include
+// doesn't match the sha256 hash it has been pinned to.
+type IntegrityError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e IntegrityError) Error() string {
+	return fmt.Sprintf("sha256 of %s does not match, pinned to %s but got %s", e.Path, e.Expected, e.Actual)
+}
+
+func contentHash(fsys fs.FS, name string) (string, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func verifyIntegrity(fsys fs.FS, name, expected string) error {
+	actual, err := contentHash(fsys, name)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, expected) {
+		return IntegrityError{Path: name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// Pin sets the sha256 attribute of each of the document's remote include
+// directives to the hash of the content they currently refer to, so that
+// resolving them fails if that content ever changes. It returns the number
+// of directives which were added to or refreshed. Pinned documents are only
+// able to include other documents which are pinned within them in turn.
+func (d *Document) Pin() (int, error) {
+	if d.opts.remote == nil {
+		d.opts.remote = newRemoteFS(d.opts.offline)
+	}
+
+	errs := errGroup{}
+	pinned := 0
+	for _, incl := range d.includes {
+		if !isRemote(incl.path) {
+			continue
+		}
+
+		sum, err := contentHash(d.opts.remote, incl.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", IncludeLink{d.displayKey(), d.srcLine(incl.linePos)}, err))
+			continue
+		}
+		if incl.attrs["sha256"] == sum {
+			continue
+		}
+
+		log.Printfln("[%s] pinning include: %s to sha256 %s", d.name, incl.path, sum)
+		line := string(d.lineContent[incl.linePos-1])
+		d.lineContent[incl.linePos-1] = []byte(setAttr(line, d.opts.directiveDelims(), "sha256", sum))
+		pinned++
+	}
+
+	return pinned, errs.toErrOrNil()
+}
+
+// setAttr returns line with the value of the given attribute of
+// the include directive within it replaced, or added if missing.
+func setAttr(line string, dl delims, key, value string) string {
+	directive := dl.unwrap(line)
+	offset := strings.Index(line, directive)
+	m := includeMatch(directive)
+	if m == nil || offset < 0 {
+		return line
+	}
+
+	start, end := offset+m[8], offset+m[9]
+	attrs := line[start:end]
+	attr := key + "=" + value
+	for _, am := range attrRegexInst.FindAllStringSubmatchIndex(attrs, -1) {
+		if attrs[am[2]:am[3]] == key {
+			return line[:start] + attrs[:am[0]] + attr + attrs[am[1]:] + line[end:]
+		}
+	}
+	return line[:end] + " " + attr + line[end:]
+}
@@ -0,0 +1,115 @@
+package md
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestPinRemoteIncludes(t *testing.T) {
+	is := is.New(t)
+	setupTestCacheDir(t)
+
+	srv := newRemoteServer(map[string]string{"/a.md": "a", "/b.md": "b"})
+	defer srv.Close()
+
+	sum := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	pinfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte(
+			"#include \"" + srv.URL + "/a.md\"\n" +
+				"<!-- #include \"" + srv.URL + "/b.md\" sha256=stale lines=1 -->\n" +
+				"#include \"local.md\"",
+		)},
+		"local.md": &fstest.MapFile{Data: []byte("local")},
+	}
+
+	doc, err := Open("README.md", pinfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	n, err := doc.Pin()
+	is.NoErr(err)
+	is.Equal(n, 2)
+	is.Equal(
+		string(mergeLines(doc.lineContent)),
+		"#include \""+srv.URL+"/a.md\" sha256="+sum("a")+"\n"+
+			"<!-- #include \""+srv.URL+"/b.md\" sha256="+sum("b")+" lines=1 -->\n"+
+			"#include \"local.md\"",
+	)
+
+	is.NoErr(doc.scan())
+	n, err = doc.Pin()
+	is.NoErr(err)
+	is.Equal(n, 0)
+}
+
+func TestPinnedIncludeHasChanged(t *testing.T) {
+	is := is.New(t)
+	setupTestCacheDir(t)
+
+	srv := newRemoteServer(map[string]string{"/snippet.md": "changed"})
+	defer srv.Close()
+
+	pinned := fmt.Sprintf("%x", sha256.Sum256([]byte("snippet")))
+	pinfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("# Title\n#include \"" + srv.URL + "/snippet.md\" sha256=" + pinned)},
+	}
+
+	doc, err := Open("README.md", pinfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", pinfs)
+	var integrityErr IntegrityError
+	is.True(errors.As(err, &integrityErr))
+	is.Equal(integrityErr.Expected, pinned)
+	is.Equal(integrityErr.Actual, fmt.Sprintf("%x", sha256.Sum256([]byte("changed"))))
+
+	srv.docs["/snippet.md"] = "snippet"
+	is.Equal(resolveAndWrite(is, "README.md", pinfs), "# Title\nsnippet\n")
+}
+
+func TestPinnedIncludesOnlyIncludePinnedDocuments(t *testing.T) {
+	is := is.New(t)
+	setupTestCacheDir(t)
+
+	srv := newRemoteServer(map[string]string{"/other.md": "other"})
+	defer srv.Close()
+
+	sum := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	unpinned := "#include \"other.md\""
+	pinned := "#include \"other.md\" sha256=" + sum("other")
+	srv.docs["/unpinned.md"], srv.docs["/pinned.md"] = unpinned, pinned
+
+	pinfs := fstest.MapFS{
+		"unpinned.md": &fstest.MapFile{Data: []byte("#include \"" + srv.URL + "/unpinned.md\" sha256=" + sum(unpinned))},
+		"pinned.md":   &fstest.MapFile{Data: []byte("#include \"" + srv.URL + "/pinned.md\" sha256=" + sum(pinned))},
+		"plain.md":    &fstest.MapFile{Data: []byte("#include \"" + srv.URL + "/unpinned.md\"")},
+	}
+
+	doc, err := Open("unpinned.md", pinfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", pinfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "unpinned.md:1: unpinned include other.md within a pinned document"))
+
+	is.Equal(resolveAndWrite(is, "pinned.md", pinfs), "other\n")
+	is.Equal(resolveAndWrite(is, "plain.md", pinfs), "other\n")
+}
+
+func TestSetAttr(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(setAttr(`#include "a.md"`, defaultDelims, "sha256", "ab"), `#include "a.md" sha256=ab`)
+	is.Equal(setAttr(`#include "a.md" sha256="x" lines=2`, defaultDelims, "sha256", "ab"), `#include "a.md" sha256=ab lines=2`)
+	is.Equal(setAttr(`> <!-- #include "a.md" -->`, defaultDelims, "sha256", "ab"), `> <!-- #include "a.md" sha256=ab -->`)
+	is.Equal(setAttr("`#include \"a.md\"` #include \"b.md\"", defaultDelims, "sha256", "ab"), "`#include \"a.md\"` #include \"b.md\" sha256=ab")
+	is.Equal(setAttr("no directive", defaultDelims, "sha256", "ab"), "no directive")
+}
@@ -0,0 +1,211 @@
+package md
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	paths "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/tauraamui/imdclude/pkg/logging"
+)
+
+// cacheDir is where the bodies of remote includes are kept, alongside rather
+// than within the backups dir as every file within that is read as a backup.
+var cacheDir = func() string {
+	usr, err := user.Current()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "imdclude-cache")
+	}
+
+	return filepath.Join(usr.HomeDir, "tmp", "imdclude-cache")
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func isRemote(p string) bool {
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+// resolveURL returns the URL ref refers to relative to the document at base.
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// remoteFS is a file system of documents fetched over HTTP(S), named by their
+// URL. Responses are cached on disk and revalidated using their ETag or
+// Last-Modified headers, and each document is only fetched once per run.
+type remoteFS struct {
+	dir     string
+	offline bool
+	fetched map[string][]byte
+}
+
+func newRemoteFS(offline bool) *remoteFS {
+	return &remoteFS{dir: cacheDir(), offline: offline, fetched: map[string][]byte{}}
+}
+
+func (r *remoteFS) searchPath() searchPath {
+	return searchPath{name: "remote", fsys: r}
+}
+
+func (r *remoteFS) Open(name string) (fs.File, error) {
+	body, err := r.fetch(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &remoteFile{Reader: bytes.NewReader(body), info: remoteFileInfo{name: remoteName(name), size: int64(len(body))}}, nil
+}
+
+func (r *remoteFS) Stat(name string) (fs.FileInfo, error) {
+	body, err := r.fetch(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return remoteFileInfo{name: remoteName(name), size: int64(len(body))}, nil
+}
+
+// cachedResponse is a response to fetching a remote document as kept on disk.
+type cachedResponse struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+func (r *remoteFS) cachePath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(r.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (r *remoteFS) cached(u string) *cachedResponse {
+	b, err := os.ReadFile(r.cachePath(u))
+	if err != nil {
+		return nil
+	}
+
+	c := cachedResponse{}
+	if err := json.Unmarshal(b, &c); err != nil || c.URL != u {
+		return nil
+	}
+	return &c
+}
+
+func (r *remoteFS) cache(c cachedResponse) error {
+	if err := os.MkdirAll(r.dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.cachePath(c.URL), b, 0644)
+}
+
+// fetch returns the body of the document at u, revalidating any cached copy
+// of it with the server, or only ever using the cached copy when offline.
+func (r *remoteFS) fetch(u string) ([]byte, error) {
+	if body, ok := r.fetched[u]; ok {
+		return body, nil
+	}
+
+	c := r.cached(u)
+	if r.offline {
+		if c == nil {
+			return nil, fmt.Errorf("not cached, unable to fetch when offline: %w", fs.ErrNotExist)
+		}
+		log.Printfln("[remote] using cached %s", u)
+		r.fetched[u] = c.Body
+		return c.Body, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		if len(c.ETag) > 0 {
+			req.Header.Set("If-None-Match", c.ETag)
+		}
+		if len(c.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", c.LastModified)
+		}
+	}
+
+	log.Printfln("[remote] fetching %s", u)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && c != nil:
+		log.Printfln("[remote] %s not modified, using cached copy", u)
+	case resp.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		c = &cachedResponse{URL: u, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"), Body: body}
+		if err := r.cache(*c); err != nil {
+			log.Printfln("[remote] unable to cache %s: %v", u, err)
+		}
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("%s: %w", resp.Status, fs.ErrNotExist)
+	default:
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	r.fetched[u] = c.Body
+	return c.Body, nil
+}
+
+// remoteName returns the file name of the document at u.
+func remoteName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || len(strings.Trim(parsed.Path, "/")) == 0 {
+		return u
+	}
+	return paths.Base(parsed.Path)
+}
+
+type remoteFile struct {
+	*bytes.Reader
+	info remoteFileInfo
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *remoteFile) Close() error               { return nil }
+
+type remoteFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi remoteFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi remoteFileInfo) IsDir() bool        { return false }
+func (fi remoteFileInfo) Sys() interface{}   { return nil }
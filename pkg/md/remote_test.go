@@ -0,0 +1,119 @@
+package md
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+type remoteServer struct {
+	*httptest.Server
+	docs        map[string]string
+	requests    map[string]int
+	notModified int
+}
+
+// newRemoteServer serves each of docs with an ETag, responding
+// to requests revalidating an unchanged doc with 304s.
+func newRemoteServer(docs map[string]string) *remoteServer {
+	rs := remoteServer{docs: docs, requests: map[string]int{}}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.requests[r.URL.Path]++
+		doc, ok := rs.docs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(doc)))
+		if r.Header.Get("If-None-Match") == etag {
+			rs.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(doc))
+	}))
+	return &rs
+}
+
+func setupTestCacheDir(t *testing.T) {
+	old := cacheDir
+	dir := t.TempDir()
+	cacheDir = func() string { return dir }
+	t.Cleanup(func() { cacheDir = old })
+}
+
+func TestRemoteIncludes(t *testing.T) {
+	is := is.New(t)
+	setupTestCacheDir(t)
+
+	srv := newRemoteServer(map[string]string{
+		"/docs/snippet.md": "# Snippet\n#include \"nested.md\"\n# Other",
+		"/docs/nested.md":  "nested",
+	})
+	defer srv.Close()
+
+	remotefs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("# Title\n#include \"" + srv.URL + "/docs/snippet.md#snippet\"")},
+	}
+
+	is.Equal(resolveAndWrite(is, "README.md", remotefs), "# Title\n# Snippet\nnested\n")
+	is.Equal(srv.requests["/docs/snippet.md"], 1)
+	is.Equal(srv.notModified, 0)
+
+	// cached copies are revalidated rather than fetched again
+	is.Equal(resolveAndWrite(is, "README.md", remotefs), "# Title\n# Snippet\nnested\n")
+	is.Equal(srv.requests["/docs/snippet.md"], 2)
+	is.Equal(srv.notModified, 2)
+
+	srv.docs["/docs/nested.md"] = "changed"
+	is.Equal(resolveAndWrite(is, "README.md", remotefs), "# Title\n# Snippet\nchanged\n")
+}
+
+func TestRemoteIncludesOffline(t *testing.T) {
+	is := is.New(t)
+	setupTestCacheDir(t)
+
+	srv := newRemoteServer(map[string]string{"/snippet.md": "snippet"})
+	defer srv.Close()
+
+	remotefs := fstest.MapFS{
+		"README.md":  &fstest.MapFile{Data: []byte("#include \"" + srv.URL + "/snippet.md\"")},
+		"missing.md": &fstest.MapFile{Data: []byte("#include \"" + srv.URL + "/missing.md\"")},
+	}
+
+	is.Equal(resolveAndWrite(is, "README.md", remotefs), "snippet\n")
+	is.Equal(resolveAndWrite(is, "README.md", remotefs, WithOffline()), "snippet\n")
+	is.Equal(srv.requests["/snippet.md"], 1)
+
+	doc, err := Open("missing.md", remotefs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.Configure(WithOffline()))
+	err = doc.ResolveIncludes(".", remotefs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "not cached, unable to fetch when offline"))
+	is.Equal(srv.requests["/missing.md"], 0)
+}
+
+func TestOptionalRemoteIncludeIsMissing(t *testing.T) {
+	is := is.New(t)
+	setupTestCacheDir(t)
+
+	srv := newRemoteServer(map[string]string{})
+	defer srv.Close()
+
+	remotefs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("#include? \"" + srv.URL + "/missing.md\" fallback=none")},
+	}
+
+	is.Equal(resolveAndWrite(is, "README.md", remotefs), "none\n")
+}
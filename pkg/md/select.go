@@ -0,0 +1,250 @@
+package md
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// srcLine returns the line number within the original source of the line at pos.
+func (d *Document) srcLine(pos int) int {
+	if d.lineNos == nil || pos < 1 || pos > len(d.lineNos) {
+		return pos
+	}
+	return d.lineNos[pos-1]
+}
+
+// filterLines removes each line at an index keep returns false for, along
+// with any includes found on them.
+func (d *Document) filterLines(keep func(i int) bool) {
+	if d.lineNos == nil {
+		d.lineNos = make([]int, len(d.lineContent))
+		for i := range d.lineNos {
+			d.lineNos[i] = i + 1
+		}
+	}
+
+	// maps each line position to the new position of the
+	// last kept line at or before it, and whether it was kept
+	newPos := make([]int, len(d.lineContent)+1)
+	kept := make([]bool, len(d.lineContent)+1)
+
+	content := make([][]byte, 0, len(d.lineContent))
+	lineNos := make([]int, 0, len(d.lineContent))
+	for i, l := range d.lineContent {
+		if keep(i) {
+			content = append(content, l)
+			lineNos = append(lineNos, d.lineNos[i])
+			kept[i+1] = true
+		}
+		newPos[i+1] = len(content)
+	}
+
+	includes := []include{}
+	for _, incl := range d.includes {
+		if !kept[incl.linePos] {
+			continue
+		}
+		incl.linePos = newPos[incl.linePos]
+		if incl.end > 0 {
+			incl.end = newPos[incl.end]
+			if incl.end <= incl.linePos {
+				incl.end = 0
+			}
+		}
+		includes = append(includes, incl)
+	}
+
+	d.lineContent, d.lineNos, d.includes = content, lineNos, includes
+}
+
+// lineBuilder builds up new content for a document out of its existing lines
+// along with lines added to them, keeping track of the line each came from
+// within the original and the new positions of the includes found on them.
+type lineBuilder struct {
+	d        *Document
+	content  [][]byte
+	lineNos  []int
+	includes []include
+}
+
+// add adds l, which came from or was added for the line at pos.
+func (b *lineBuilder) add(l []byte, pos int) {
+	b.content = append(b.content, l)
+	b.lineNos = append(b.lineNos, b.d.srcLine(pos))
+}
+
+// addInclude adds the lines from the include's directive at the index from
+// up to the index to, which holds any content left behind by a previous
+// resolution, along with the include itself.
+func (b *lineBuilder) addInclude(incl include, from, to int) {
+	incl.linePos = len(b.content) + 1
+	if incl.end > 0 {
+		incl.end = len(b.content) + to - from
+	}
+	for i := from; i < to; i++ {
+		b.add(b.d.lineContent[i], i+1)
+	}
+	b.includes = append(b.includes, incl)
+}
+
+// apply replaces the document's lines and includes with those built up.
+func (b *lineBuilder) apply() {
+	b.d.lineContent, b.d.lineNos, b.d.includes = b.content, b.lineNos, b.includes
+}
+
+// keepLines removes all lines outside of the range from and to,
+// along with any includes found within them.
+func (d *Document) keepLines(from, to int) {
+	d.filterLines(func(i int) bool {
+		return i >= from && i < to
+	})
+}
+
+// selectContent removes all of the document's lines other
+// than those the attributes of the given include select.
+func (d *Document) selectContent(incl include) error {
+	if lines, ok := incl.attrs["lines"]; ok {
+		if err := d.selectLines(lines); err != nil {
+			return err
+		}
+	}
+
+	if region, ok := incl.attrs["region"]; ok {
+		if err := d.selectRegion(region); err != nil {
+			return err
+		}
+	}
+
+	if len(incl.anchor) > 0 {
+		return d.selectSection(incl.anchor)
+	}
+
+	return nil
+}
+
+// selectLines removes all lines outside of the given range of line numbers,
+// given as either a single line number, or a start and end line number either
+// of which can be left out to run from the start or to the end of the document.
+func (d *Document) selectLines(r string) error {
+	from, to, err := parseLineRange(r, len(d.lineContent))
+	if err != nil {
+		return fmt.Errorf("invalid line range %q for %s: %w", r, d.displayKey(), err)
+	}
+	if from > len(d.lineContent) {
+		return fmt.Errorf("line range %q for %s starts past its last line %d", r, d.displayKey(), len(d.lineContent))
+	}
+
+	d.keepLines(from-1, to)
+	return nil
+}
+
+func parseLineRange(r string, last int) (int, int, error) {
+	bounds := strings.SplitN(r, "-", 2)
+	if len(bounds) == 1 {
+		bounds = append(bounds, bounds[0])
+	}
+
+	from, to := 1, last
+	if len(bounds[0]) > 0 {
+		n, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		from = n
+	}
+	if len(bounds[1]) > 0 {
+		n, err := strconv.Atoi(bounds[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		to = n
+	}
+
+	if from < 1 || to < from {
+		return 0, 0, fmt.Errorf("range must run forwards from line 1 onwards")
+	}
+	if to > last {
+		to = last
+	}
+	return from, to, nil
+}
+
+var (
+	regionStartRegex = regexp.MustCompile(`^\s*(?:<!--|//)\s*region:?\s+(\S+?)\s*(?:-->)?\s*$`)
+	regionEndRegex   = regexp.MustCompile(`^\s*(?:<!--|//)\s*endregion\b.*$`)
+)
+
+// selectRegion removes all lines outside of the named region, marked out by
+// a <!-- region: name --> comment and a matching <!-- endregion --> comment,
+// or // region: name and // endregion comments within code. The markers of
+// the region, and any others nested within it, are removed too.
+func (d *Document) selectRegion(name string) error {
+	available := []string{}
+	start, depth := -1, 0
+	for i, l := range d.lineContent {
+		if m := regionStartRegex.FindSubmatch(l); m != nil {
+			if start >= 0 {
+				depth++
+				continue
+			}
+			if string(m[1]) == name {
+				start = i
+				continue
+			}
+			available = append(available, string(m[1]))
+			continue
+		}
+
+		if !regionEndRegex.Match(l) || start < 0 {
+			continue
+		}
+		if depth > 0 {
+			depth--
+			continue
+		}
+
+		d.filterLines(func(j int) bool {
+			return j > start && j < i && !regionStartRegex.Match(d.lineContent[j]) && !regionEndRegex.Match(d.lineContent[j])
+		})
+		return nil
+	}
+
+	if start >= 0 {
+		return fmt.Errorf("region %s in %s is missing its endregion marker", name, d.displayKey())
+	}
+	return fmt.Errorf("region %s not found in %s, available regions: %s", name, d.displayKey(), listOrNone(available))
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+// selectSection removes all lines outside of the section under the heading
+// with the given anchor, which runs up to the next heading of the same or
+// a higher level.
+func (d *Document) selectSection(a string) error {
+	headings := findHeadings(d.lineContent)
+	as := anchors(headings)
+	for i, h := range headings {
+		if as[i] != a {
+			continue
+		}
+
+		end := len(d.lineContent)
+		for _, next := range headings[i+1:] {
+			if next.level <= h.level {
+				end = next.line
+				break
+			}
+		}
+		d.keepLines(h.line, end)
+		return nil
+	}
+
+	return fmt.Errorf("anchor #%s not found in %s, available anchors: %s", a, d.displayKey(), listOrNone(as))
+}
@@ -0,0 +1,125 @@
+package md
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var tocRegex = regexp.MustCompile(`^#toc((?:[ \t]+[\w-]+=(?:"[^"]*"|[^\s"]+))*)[ \t]*$`)
+
+const tocTarget = "toc"
+
+// isTOC returns the attributes of the #toc directive within l, such as
+// min=2 max=4, which are empty for a directive without any.
+func isTOC(l string) (map[string]string, bool) {
+	m := tocRegex.FindStringSubmatch(strings.TrimSpace(l))
+	if m == nil {
+		return nil, false
+	}
+	return parseAttrs(m[1]), true
+}
+
+// tocLevels returns the range of heading levels a #toc directive
+// with the given attributes lists, which by default is all of them.
+func tocLevels(attrs map[string]string) (int, int, error) {
+	levels := [2]int{1, 6}
+	for i, name := range []string{"min", "max"} {
+		v, ok := attrs[name]
+		if !ok {
+			continue
+		}
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 1 || level > 6 {
+			return 0, 0, fmt.Errorf("invalid #toc %s %q, must be a heading level from 1 to 6", name, v)
+		}
+		levels[i] = level
+	}
+
+	if levels[0] > levels[1] {
+		return 0, 0, fmt.Errorf("invalid #toc levels, min %d is greater than max %d", levels[0], levels[1])
+	}
+	return levels[0], levels[1], nil
+}
+
+// expandTOCs replaces each #toc directive within the resolved document with
+// a nested list of links to its headings, including those of every included
+// document. Alongside markers the directive is kept, with the list wrapped
+// within markers so that it's replaced when the document is resolved again.
+func (d *Document) expandTOCs() error {
+	errs := errGroup{}
+	delims := d.opts.directiveDelims()
+	code := codeLines(d.lineContent)
+	origins := d.lineOrigins(len(d.lineContent))
+	headings := findHeadings(d.lineContent)
+	ids := anchors(headings)
+
+	content := make([][]byte, 0, len(d.lineContent))
+	contentOrigins := make([]*Document, 0, len(d.lineContent))
+	for i := 0; i < len(d.lineContent); i++ {
+		attrs, ok := isTOC(delims.unwrap(string(d.lineContent[i])))
+		if code[i] || !ok {
+			content = append(content, d.lineContent[i])
+			contentOrigins = append(contentOrigins, origins[i])
+			continue
+		}
+
+		// a list left behind by a previous resolution is replaced
+		end, err := d.findEndMarker(i + 1)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		min, max, err := tocLevels(attrs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", origins[i].displayKey(), err))
+			end = 0
+		}
+
+		lines := [][]byte{}
+		if err == nil {
+			lines = toc(headings, ids, min, max)
+		}
+		if d.opts.markers || err != nil {
+			content = append(content, d.lineContent[i])
+			contentOrigins = append(contentOrigins, origins[i])
+		}
+		if d.opts.markers && err == nil {
+			lines = append([][]byte{beginMarker(tocTarget)}, append(lines, endMarker(tocTarget))...)
+		}
+
+		content = append(content, prefixLines(lines, containerPrefix(d.lineContent[i]))...)
+		for range lines {
+			contentOrigins = append(contentOrigins, origins[i])
+		}
+
+		if end > 0 {
+			i = end - 1
+		}
+	}
+
+	d.lineContent, d.origins = content, contentOrigins
+	return errs.toErrOrNil()
+}
+
+// toc returns a nested list of links to the given headings with levels
+// between min and max, nested relative to the highest level among them.
+func toc(headings []heading, ids []string, min, max int) [][]byte {
+	top := 0
+	for _, h := range headings {
+		if h.level >= min && h.level <= max && (top == 0 || h.level < top) {
+			top = h.level
+		}
+	}
+
+	lines := [][]byte{}
+	for i, h := range headings {
+		if h.level < min || h.level > max || len(h.text) == 0 {
+			continue
+		}
+		indent := strings.Repeat("  ", h.level-top)
+		lines = append(lines, []byte(fmt.Sprintf("%s- [%s](#%s)", indent, strings.TrimSpace(plainText(h.text)), ids[i])))
+	}
+	return lines
+}
@@ -0,0 +1,58 @@
+package md
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var tocfs = fstest.MapFS{
+	"README.md":  &fstest.MapFile{Data: []byte("# Manual\n<!-- #toc min=2 max=4 -->\n## Intro\n#include \"usage.md\" shift=1\n## Example")},
+	"usage.md":   &fstest.MapFile{Data: []byte("# Usage\n## Flags `-v`\n### Verbose\n#### Too deep\n## Example\n```\n## Not a heading\n```")},
+	"all.md":     &fstest.MapFile{Data: []byte("- item\n  #toc\n# A\nB\n-\n### [C](https://example.com)")},
+	"invalid.md": &fstest.MapFile{Data: []byte("#toc min=4 max=2\n#toc max=seven\n# A")},
+}
+
+func TestTOCListsHeadingsOfIncludedDocuments(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", tocfs),
+		"# Manual\n- [Intro](#intro)\n- [Usage](#usage)\n  - [Flags -v](#flags--v)\n    - [Verbose](#verbose)\n  - [Example](#example)\n- [Example](#example-1)\n"+
+			"## Intro\n## Usage\n### Flags `-v`\n#### Verbose\n##### Too deep\n### Example\n```\n## Not a heading\n```\n## <a id=\"example-1\"></a>Example\n",
+	)
+}
+
+func TestTOCWithinContainerListsAllLevels(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "all.md", tocfs),
+		"- item\n  - [A](#a)\n    - [B](#b)\n      - [C](#c)\n# A\nB\n-\n### [C](https://example.com)\n",
+	)
+}
+
+func TestTOCWithMarkersIsReplacedWhenResolvedAgain(t *testing.T) {
+	is := is.New(t)
+
+	once := resolveAndWrite(is, "README.md", tocfs, WithMarkers())
+	is.True(strings.HasPrefix(once, "# Manual\n<!-- #toc min=2 max=4 -->\n<!-- mdx:begin \"toc\" -->\n- [Intro](#intro)\n"))
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}, "usage.md": tocfs["usage.md"]}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers()), once)
+}
+
+func TestInvalidTOCLevels(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("invalid.md", tocfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", tocfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "invalid.md: invalid #toc levels, min 4 is greater than max 2"))
+	is.True(strings.Contains(err.Error(), `invalid.md: invalid #toc max "seven", must be a heading level from 1 to 6`))
+}
@@ -0,0 +1,414 @@
+package md
+
+import (
+	"fmt"
+	"os"
+	paths "path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	defineRegex   = regexp.MustCompile(`^#define[ \t]+([A-Za-z_]\w*)(?:[ \t]+(.*))?$`)
+	undefRegex    = regexp.MustCompile(`^#undef[ \t]+([A-Za-z_]\w*)[ \t]*$`)
+	variableRegex = regexp.MustCompile(`\\?\$\{([A-Za-z_]\w*)\}`)
+)
+
+// WithDefines defines variables for ${NAME} placeholders to expand to, which
+// take precedence over the environment but can be redefined by documents.
+func WithDefines(vars map[string]string) Option {
+	return func(o *options) {
+		if o.defines == nil {
+			o.defines = map[string]string{}
+		}
+		for k, v := range vars {
+			o.defines[k] = v
+		}
+	}
+}
+
+// WithEnvironment makes every environment variable available for ${NAME}
+// placeholders to expand to, unless defined in any other way.
+func WithEnvironment() Option {
+	return func(o *options) {
+		o.environment = true
+	}
+}
+
+// WithUndefinedVariables leaves placeholders for variables which haven't
+// been defined as they are, rather than failing resolution.
+func WithUndefinedVariables() Option {
+	return func(o *options) {
+		o.keepUndefined = true
+	}
+}
+
+// initialVars returns the variables defined before any document is read.
+func (o options) initialVars() map[string]string {
+	vars := map[string]string{}
+	if o.environment {
+		for _, kv := range os.Environ() {
+			if i := strings.Index(kv, "="); i > 0 {
+				vars[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	for k, v := range o.defines {
+		vars[k] = v
+	}
+	return vars
+}
+
+func copyVars(vars map[string]string) map[string]string {
+	c := make(map[string]string, len(vars))
+	for k, v := range vars {
+		c[k] = v
+	}
+	return c
+}
+
+// expand returns l with each ${NAME} placeholder within it replaced with the
+// value of the variable, and each escaped \${NAME} placeholder unescaped.
+func expand(l string, vars map[string]string, keepUndefined bool) (string, []string) {
+	if !strings.Contains(l, "${") {
+		return l, nil
+	}
+
+	undefined := []string{}
+	expanded := variableRegex.ReplaceAllStringFunc(l, func(m string) string {
+		if strings.HasPrefix(m, `\`) {
+			return m[1:]
+		}
+		name := m[2 : len(m)-1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		if !keepUndefined {
+			undefined = append(undefined, name)
+		}
+		return m
+	})
+	return expanded, undefined
+}
+
+const (
+	// varsTarget is what the markers around lines with their
+	// placeholders expanded are for
+	varsTarget = "${}"
+	// condTarget is what the markers around the branch
+	// of a conditional block being kept are for
+	condTarget = "#if"
+)
+
+// preprocess works through the document line by line, defining variables for
+// each #define directive and removing them for each #undef, removing the lines
+// of each conditional block's branches which don't hold true, and expanding
+// placeholders within every other line with the variables defined by then.
+// Includes are found again within their expanded directives, and are given
+// a copy of the variables defined at that point, so that definitions flow
+// down into included documents but never back up out of them.
+// Alongside markers, lines with placeholders are kept hidden away, followed
+// by their expanded lines within markers, with code hidden away as a whole
+// block, as are the directives themselves and the lines of branches which
+// don't hold true, with the branch which does wrapped within markers, for the
+// document to be resolved again with different definitions. Front matter
+// can't hold markers, so is always expanded in place.
+func (d *Document) preprocess(vars map[string]string) error {
+	if d.raw {
+		return nil
+	}
+
+	errs := errGroup{}
+	if err := d.restoreSource(); err != nil {
+		errs = append(errs, err)
+	}
+
+	delims := d.opts.directiveDelims()
+	code := codeLines(d.lineContent)
+	conds := conditionals{}
+	b := lineBuilder{d: d}
+
+	// consecutive lines with placeholders are expanded within the same markers
+	type expansion struct {
+		pos    int
+		prefix []byte
+		src    [][]byte
+		lines  [][]byte
+	}
+	pending := []expansion{}
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		for _, e := range pending {
+			b.add(hiddenLines(e.prefix, e.src), e.pos)
+		}
+
+		// the expanded lines already sit within the same containers as the first
+		prefix := pending[0].prefix
+		b.add(prefixLines([][]byte{beginMarker(varsTarget)}, prefix)[0], pending[0].pos)
+		for _, e := range pending {
+			for j, l := range e.lines {
+				b.add(l, e.pos+j)
+			}
+		}
+		last := pending[len(pending)-1]
+		b.add(prefixLines([][]byte{endMarker(varsTarget)}, prefix)[0], last.pos+len(last.src)-1)
+		pending = pending[:0]
+	}
+	add := func(l []byte, pos int) {
+		flush()
+		b.add(l, pos)
+	}
+
+	fmSize := 0
+	if fm, err := parseFrontMatter(d.lineContent); err == nil && fm != nil {
+		fmSize = fm.size
+	}
+
+	next := 0
+	for i := 0; i < len(d.lineContent); i++ {
+		if i < fmSize {
+			l, undefined := expand(string(d.lineContent[i]), vars, d.opts.keepUndefined)
+			if len(undefined) > 0 {
+				errs = append(errs, d.undefinedVariables(i+1, undefined))
+				l = string(d.lineContent[i])
+			}
+			add([]byte(l), i+1)
+			continue
+		}
+
+		if next < len(d.includes) && d.includes[next].linePos == i+1 {
+			incl := &d.includes[next]
+			next++
+
+			end := i + 1
+			if incl.end > 0 {
+				end = incl.end
+			}
+
+			if !conds.active() {
+				if d.opts.markers {
+					add(hiddenMarker(d.lineContent[i]), i+1)
+				}
+			} else {
+				// the directive itself is kept as it was, for markers to be resolved again
+				l, undefined := expand(string(d.lineContent[i]), vars, d.opts.keepUndefined)
+				if len(undefined) > 0 {
+					errs = append(errs, d.undefinedVariables(i+1, undefined))
+				} else if expanded, ok := isInclude(delims.unwrap(l)); ok {
+					incl.path, incl.name, incl.anchor, incl.attrs = expanded.path, paths.Base(expanded.path), expanded.anchor, expanded.attrs
+					incl.optional = expanded.optional
+				}
+				incl.vars = copyVars(vars)
+
+				flush()
+				b.addInclude(*incl, i, end)
+			}
+
+			i = end - 1
+			continue
+		}
+
+		l := d.lineContent[i]
+		if code[i] {
+			// code is expanded and hidden as a whole, for none of it to escape the markers
+			end := i + 1
+			for end < len(d.lineContent) && code[end] && !(next < len(d.includes) && d.includes[next].linePos == end+1) {
+				end++
+			}
+			block, prefix := d.lineContent[i:end], codePrefix(l)
+
+			if !conds.active() {
+				if d.opts.markers {
+					add(hiddenLines(prefix, block), i+1)
+				}
+				i = end - 1
+				continue
+			}
+
+			expanded, changed := make([][]byte, len(block)), false
+			for j, cl := range block {
+				e, undefined := expand(string(cl), vars, d.opts.keepUndefined)
+				if len(undefined) > 0 {
+					errs = append(errs, d.undefinedVariables(i+j+1, undefined))
+					e = string(cl)
+				}
+				expanded[j], changed = []byte(e), changed || e != string(cl)
+			}
+
+			if d.opts.markers && changed {
+				pending = append(pending, expansion{pos: i + 1, prefix: prefix, src: block, lines: expanded})
+			} else {
+				for j, e := range expanded {
+					add(e, i+j+1)
+				}
+			}
+			i = end - 1
+			continue
+		}
+
+		directive := strings.TrimSpace(delims.unwrap(string(l)))
+		if m := conditionalRegex.FindStringSubmatch(directive); m != nil {
+			// whether the directive ends the branch being kept
+			ending := len(conds.enclosing(m[1])) < len(conds) && conds.active()
+
+			var err error
+			if conds, err = d.applyConditional(conds, i+1, m, vars); err != nil {
+				errs = append(errs, err)
+			}
+
+			// alongside markers the branch being kept is wrapped within them
+			if d.opts.markers {
+				prefix := containerPrefix(l)
+				if ending {
+					add(prefixLines([][]byte{endMarker(condTarget)}, prefix)[0], i+1)
+				}
+				add(hiddenMarker(l), i+1)
+				if err == nil && m[1] != "endif" && conds.active() {
+					add(prefixLines([][]byte{beginMarker(condTarget)}, prefix)[0], i+1)
+				}
+			}
+			continue
+		}
+
+		if !conds.active() {
+			if d.opts.markers {
+				add(hiddenMarker(l), i+1)
+			}
+			continue
+		}
+
+		// definitions are only kept alongside markers, for the document to be resolved again
+		if m := defineRegex.FindStringSubmatch(directive); m != nil {
+			value, undefined := expand(strings.TrimSpace(m[2]), vars, d.opts.keepUndefined)
+			if len(undefined) > 0 {
+				errs = append(errs, d.undefinedVariables(i+1, undefined))
+			}
+			vars[m[1]] = value
+			if d.opts.markers {
+				add(hiddenMarker(l), i+1)
+			}
+			continue
+		}
+		if m := undefRegex.FindStringSubmatch(directive); m != nil {
+			delete(vars, m[1])
+			if d.opts.markers {
+				add(hiddenMarker(l), i+1)
+			}
+			continue
+		}
+
+		expanded, undefined := expand(string(l), vars, d.opts.keepUndefined)
+		switch {
+		case len(undefined) > 0:
+			errs = append(errs, d.undefinedVariables(i+1, undefined))
+			add(l, i+1)
+		case d.opts.markers && expanded != string(l):
+			pending = append(pending, expansion{pos: i + 1, prefix: containerPrefix(l), src: [][]byte{l}, lines: [][]byte{[]byte(expanded)}})
+		default:
+			add([]byte(expanded), i+1)
+		}
+	}
+	flush()
+	errs = append(errs, d.unclosed(conds)...)
+
+	b.apply()
+	return errs.toErrOrNil()
+}
+
+// restoreSource brings back the lines of the document as they were before it
+// was last resolved alongside markers, unhiding the lines which were hidden
+// and removing the lines generated from them, for them to be resolved again.
+func (d *Document) restoreSource() error {
+	code := codeLines(d.lineContent)
+	b := lineBuilder{d: d}
+	restored := false
+
+	next := 0
+	for i := 0; i < len(d.lineContent); i++ {
+		// the content of includes is replaced when they're resolved again
+		if next < len(d.includes) && d.includes[next].linePos == i+1 {
+			end := i + 1
+			if d.includes[next].end > 0 {
+				end = d.includes[next].end
+			}
+			b.addInclude(d.includes[next], i, end)
+			next++
+			i = end - 1
+			continue
+		}
+
+		l := d.lineContent[i]
+		if code[i] {
+			b.add(l, i+1)
+			continue
+		}
+
+		if src, ok := unhide(l); ok {
+			for _, sl := range src {
+				b.add(sl, i+1)
+			}
+			restored = true
+			continue
+		}
+		if isMarkerOf(l, beginMarker(condTarget)) || isMarkerOf(l, endMarker(condTarget)) {
+			restored = true
+			continue
+		}
+		if isMarkerOf(l, beginMarker(varsTarget)) {
+			if end, err := d.findEndMarker(i); err == nil && end > 0 {
+				i = end - 1
+				restored = true
+				continue
+			}
+		}
+		b.add(l, i+1)
+	}
+
+	if !restored {
+		return nil
+	}
+
+	// hidden lines may well have been include directives
+	b.apply()
+	return d.scan()
+}
+
+func (d *Document) undefinedVariables(pos int, names []string) error {
+	unique := map[string]bool{}
+	for _, n := range names {
+		unique[n] = true
+	}
+	names = names[:0]
+	for n := range unique {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("%s: undefined variables: %s", IncludeLink{d.displayKey(), d.srcLine(pos)}, strings.Join(names, ", "))
+}
+
+// codePrefix returns the containers of the code block starting with l, other
+// than the indentation which makes it an indented code block, so that markers
+// hiding it don't become part of an indented code block themselves.
+func codePrefix(l []byte) []byte {
+	prefix := containerPrefix(l)
+	if _, ok := openingFence(l); ok {
+		return prefix
+	}
+
+	for w := 0; w < tabWidth && len(prefix) > 0; {
+		switch prefix[len(prefix)-1] {
+		case ' ':
+			w++
+		case '\t':
+			w = tabWidth
+		default:
+			return prefix
+		}
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
@@ -0,0 +1,171 @@
+package md
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+var varsfs = fstest.MapFS{
+	"README.md": &fstest.MapFile{Data: []byte(
+		"#define PRODUCT Widget\n# ${PRODUCT} ${EDITION} docs\n#include \"${EDITION}/intro.md\"\n<!-- #undef PRODUCT -->\nescaped \\${PRODUCT}",
+	)},
+	"community/intro.md":  &fstest.MapFile{Data: []byte("#define WHO everyone\n${PRODUCT} for ${WHO}\n#include \"../who.md\"")},
+	"enterprise/intro.md": &fstest.MapFile{Data: []byte("${PRODUCT} for business")},
+	"who.md":              &fstest.MapFile{Data: []byte("by ${WHO}")},
+	"code.md":             &fstest.MapFile{Data: []byte("```sh\n#define NOT_A_DIRECTIVE\n```\n#include-code \"run.sh\"")},
+	"run.sh":              &fstest.MapFile{Data: []byte("echo ${HOME}")},
+	"shell.md":            &fstest.MapFile{Data: []byte("# ${PRODUCT}\n```sh\ngo install example.com/mdx@${VERSION}\necho \\${HOME}\n```\n\n    echo ${VERSION}\n\n`${PRODUCT}` is ${PRODUCT}")},
+	"undefined.md":        &fstest.MapFile{Data: []byte("# ${TITLE}\n#include \"${EDITION}/intro.md\"")},
+}
+
+func TestVariablesAreExpanded(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", varsfs, WithDefines(map[string]string{"EDITION": "community"})),
+		"# Widget community docs\nWidget for everyone\nby everyone\nescaped ${PRODUCT}\n",
+	)
+	is.Equal(
+		resolveAndWrite(is, "README.md", varsfs, WithDefines(map[string]string{"EDITION": "enterprise"})),
+		"# Widget enterprise docs\nWidget for business\nescaped ${PRODUCT}\n",
+	)
+}
+
+func TestVariableDirectivesAreKeptWithMarkers(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", varsfs, WithMarkers(), WithDefines(map[string]string{"EDITION": "enterprise"})),
+		"<!-- mdx:hidden \"#define PRODUCT Widget\" -->\n<!-- mdx:hidden \"# ${PRODUCT} ${EDITION} docs\" -->\n<!-- mdx:begin \"${}\" -->\n# Widget enterprise docs\n<!-- mdx:end \"${}\" -->\n"+
+			"#include \"${EDITION}/intro.md\"\n<!-- mdx:begin \"enterprise/intro.md\" -->\nWidget for business\n<!-- mdx:end \"enterprise/intro.md\" -->\n"+
+			"<!-- mdx:hidden \"<!-- #undef PRODUCT --\\x3e\" -->\n<!-- mdx:hidden \"escaped \\\\${PRODUCT}\" -->\n<!-- mdx:begin \"${}\" -->\nescaped ${PRODUCT}\n<!-- mdx:end \"${}\" -->\n",
+	)
+}
+
+func TestVariablesWithMarkersAreExpandedAgain(t *testing.T) {
+	is := is.New(t)
+
+	markersfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("> Version ${V} of\n> <!-- ${NAME} -->\n\nplain")},
+	}
+
+	once := resolveAndWrite(is, "README.md", markersfs, WithMarkers(), WithDefines(map[string]string{"V": "1", "NAME": "a --> b"}))
+	is.Equal(
+		once,
+		"> <!-- mdx:hidden \"Version ${V} of\" -->\n> <!-- mdx:hidden \"<!-- ${NAME} --\\x3e\" -->\n"+
+			"> <!-- mdx:begin \"${}\" -->\n> Version 1 of\n> <!-- a --> b -->\n> <!-- mdx:end \"${}\" -->\n\nplain\n",
+	)
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers(), WithDefines(map[string]string{"V": "1", "NAME": "a --> b"})), once)
+	is.Equal(
+		resolveAndWrite(is, "README.md", rerunfs, WithMarkers(), WithDefines(map[string]string{"V": "2", "NAME": "c"})),
+		resolveAndWrite(is, "README.md", markersfs, WithMarkers(), WithDefines(map[string]string{"V": "2", "NAME": "c"})),
+	)
+	is.Equal(
+		resolveAndWrite(is, "README.md", rerunfs, WithDefines(map[string]string{"V": "2", "NAME": "c"})),
+		"> Version 2 of\n> <!-- c -->\n\nplain\n",
+	)
+}
+
+func TestFrontMatterWithMarkersIsExpandedInPlace(t *testing.T) {
+	is := is.New(t)
+
+	fmfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte("---\ntitle: ${T}\n---\n# ${T}")}}
+	is.Equal(
+		resolveAndWrite(is, "README.md", fmfs, WithMarkers(), WithDefines(map[string]string{"T": "x"})),
+		"---\ntitle: x\n---\n<!-- mdx:hidden \"# ${T}\" -->\n<!-- mdx:begin \"${}\" -->\n# x\n<!-- mdx:end \"${}\" -->\n",
+	)
+}
+
+func TestVariablesFromEnvironment(t *testing.T) {
+	is := is.New(t)
+
+	os.Setenv("IMDCLUDE_TEST_EDITION", "enterprise")
+	defer os.Unsetenv("IMDCLUDE_TEST_EDITION")
+
+	envfs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("${IMDCLUDE_TEST_EDITION} ${EDITION}")},
+	}
+
+	is.Equal(
+		resolveAndWrite(is, "README.md", envfs, WithEnvironment(), WithDefines(map[string]string{"EDITION": "community"})),
+		"enterprise community\n",
+	)
+}
+
+func TestVariablesAreNotExpandedWithinCodeIncludes(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(resolveAndWrite(is, "code.md", varsfs), "```sh\n#define NOT_A_DIRECTIVE\n```\n```sh\necho ${HOME}\n```\n")
+}
+
+func TestVariablesAreExpandedWithinCode(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(
+		resolveAndWrite(is, "shell.md", varsfs, WithDefines(map[string]string{"PRODUCT": "Widget", "VERSION": "v1.2.0"})),
+		"# Widget\n```sh\ngo install example.com/mdx@v1.2.0\necho ${HOME}\n```\n\n    echo v1.2.0\n\n`Widget` is Widget\n",
+	)
+}
+
+func TestCodeWithMarkersIsExpandedAgain(t *testing.T) {
+	is := is.New(t)
+
+	codefs := fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("> ```go\n> func main() {\n> \tfmt.Println(\"${V}\")\n>\n> }\n> ```\n\nText\n\n    echo ${V}\n\n    done")},
+	}
+
+	once := resolveAndWrite(is, "README.md", codefs, WithMarkers(), WithDefines(map[string]string{"V": "1"}))
+	is.Equal(
+		once,
+		"> <!-- mdx:hidden \"```go\\nfunc main() {\\n\\tfmt.Println(\\\"${V}\\\")\\n\\n}\\n```\" -->\n"+
+			"> <!-- mdx:begin \"${}\" -->\n> ```go\n> func main() {\n> \tfmt.Println(\"1\")\n>\n> }\n> ```\n> <!-- mdx:end \"${}\" -->\n\nText\n\n"+
+			"<!-- mdx:hidden \"    echo ${V}\" -->\n<!-- mdx:begin \"${}\" -->\n    echo 1\n<!-- mdx:end \"${}\" -->\n\n    done\n",
+	)
+
+	rerunfs := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte(once)}}
+	is.Equal(resolveAndWrite(is, "README.md", rerunfs, WithMarkers(), WithDefines(map[string]string{"V": "1"})), once)
+	is.Equal(
+		resolveAndWrite(is, "README.md", rerunfs, WithDefines(map[string]string{"V": "2"})),
+		"> ```go\n> func main() {\n> \tfmt.Println(\"2\")\n>\n> }\n> ```\n\nText\n\n    echo 2\n\n    done\n",
+	)
+}
+
+func TestUndefinedVariables(t *testing.T) {
+	is := is.New(t)
+
+	doc, err := Open("undefined.md", varsfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	err = doc.ResolveIncludes(".", varsfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "undefined.md:1: undefined variables: TITLE"))
+	is.True(strings.Contains(err.Error(), "undefined.md:2: undefined variables: EDITION"))
+
+	doc, err = Open("undefined.md", varsfs)
+	is.NoErr(err)
+	defer doc.Close()
+
+	is.NoErr(doc.Configure(WithUndefinedVariables()))
+	err = doc.ResolveIncludes(".", varsfs)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "path: ${EDITION}/intro.md"))
+}
+
+func TestExpand(t *testing.T) {
+	is := is.New(t)
+
+	vars := map[string]string{"A": "1", "B_2": "two"}
+	l, undefined := expand("${A}-${B_2} ${C} \\${A} $A ${}", vars, false)
+	is.Equal(l, "1-two ${C} ${A} $A ${}")
+	is.Equal(undefined, []string{"C"})
+
+	_, undefined = expand("${C}", vars, true)
+	is.Equal(len(undefined), 0)
+}